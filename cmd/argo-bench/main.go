@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/openxt/openxt-go/pkg/argo"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	domid     = flag.IntP("domain", "d", 0, "server domain id")
+	port      = flag.IntP("port", "p", 5560, "server port")
+	server    = flag.BoolP("listen", "l", false, "run as the benchmark server")
+	ringSizes = flag.UintSlice("ring-sizes", []uint{64 * 1024, 256 * 1024, 1024 * 1024}, "receive ring sizes for throughput tests")
+	msgSizes  = flag.UintSlice("msg-sizes", []uint{64, 1024, 16 * 1024, 64 * 1024}, "message sizes for throughput and latency tests")
+	total     = flag.Uint64("bytes", 64*1024*1024, "bytes transferred per throughput test")
+	count     = flag.Uint64("count", 10000, "round trips per latency test")
+	connects  = flag.Uint64("connects", 1000, "connections made for the connection setup test")
+	tests     = flag.StringSlice("tests", []string{"throughput", "latency", "connect"}, "tests to run")
+	asJSON    = flag.Bool("json", false, "report results as JSON")
+)
+
+// Each benchmark connection starts with a request from the client telling
+// the server which test to run.
+const (
+	modeThroughput uint8 = iota + 1
+	modeLatency
+	modeConnect
+)
+
+// Limits on what a client may ask of the server, which allocates a message
+// buffer of the requested size and serves every connection concurrently.
+const (
+	maxMsgSize    = 1024 * 1024
+	maxBytes      = 64 * 1024 * 1024 * 1024
+	maxRoundTrips = 10000000
+)
+
+type request struct {
+	Mode    uint8
+	Pad     [3]uint8
+	MsgSize uint32
+	Count   uint64
+}
+
+type throughputResult struct {
+	RingSize uint32  `json:"ring_size"`
+	MsgSize  uint32  `json:"msg_size"`
+	Bytes    uint64  `json:"bytes"`
+	Seconds  float64 `json:"seconds"`
+	MBps     float64 `json:"mbytes_per_sec"`
+}
+
+type latencyResult struct {
+	MsgSize uint32  `json:"msg_size"`
+	Count   uint64  `json:"count"`
+	Min     float64 `json:"min_us"`
+	P50     float64 `json:"p50_us"`
+	P90     float64 `json:"p90_us"`
+	P99     float64 `json:"p99_us"`
+	Max     float64 `json:"max_us"`
+}
+
+type connectResult struct {
+	Count   uint64  `json:"count"`
+	Seconds float64 `json:"seconds"`
+	PerSec  float64 `json:"connects_per_sec"`
+}
+
+type results struct {
+	Throughput []throughputResult `json:"throughput,omitempty"`
+	Latency    []latencyResult    `json:"latency,omitempty"`
+	Connect    *connectResult     `json:"connect,omitempty"`
+}
+
+func die(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "err: "+format, a...)
+	fmt.Fprintln(os.Stderr)
+	os.Exit(1)
+}
+
+func serve(c *argo.Conn) error {
+	defer c.Close()
+
+	var req request
+	if err := binary.Read(c, binary.LittleEndian, &req); err != nil {
+		return err
+	}
+
+	if req.MsgSize > maxMsgSize {
+		return fmt.Errorf("message size %d exceeds the limit of %d", req.MsgSize, maxMsgSize)
+	}
+
+	switch req.Mode {
+	case modeThroughput:
+		if req.MsgSize == 0 {
+			return errors.New("throughput request with zero message size")
+		}
+		if req.Count > maxBytes {
+			return fmt.Errorf("throughput request for %d bytes exceeds the limit of %d", req.Count, uint64(maxBytes))
+		}
+		buf := make([]byte, req.MsgSize)
+		for sent := uint64(0); sent < req.Count; {
+			n := uint64(len(buf))
+			if req.Count-sent < n {
+				n = req.Count - sent
+			}
+			w, err := c.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+			sent += uint64(w)
+		}
+		// Wait for the client to confirm receipt before closing
+		_, err := c.Read(buf[:1])
+		return err
+	case modeLatency:
+		if req.Count > maxRoundTrips {
+			return fmt.Errorf("latency request for %d round trips exceeds the limit of %d", req.Count, maxRoundTrips)
+		}
+		buf := make([]byte, req.MsgSize)
+		for i := uint64(0); i < req.Count; i++ {
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return err
+			}
+			if _, err := c.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	case modeConnect:
+		_, err := c.Write([]byte{0})
+		return err
+	default:
+		return fmt.Errorf("unknown test mode %d", req.Mode)
+	}
+}
+
+func listener(port int) {
+	l, err := argo.Listen(syscall.SOCK_STREAM, port, argo.XEN_ARGO_DOMID_ANY)
+	if err != nil {
+		die("argo listen on port %d failed: %v", port, err)
+	}
+	defer l.Close()
+
+	for {
+		c, err := l.AcceptArgo()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "err: argo accept error: ", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		go func(c *argo.Conn) {
+			if err := serve(c); err != nil && err != io.EOF {
+				fmt.Fprintf(os.Stderr, "err: %v: %v\n", c.RemoteAddr(), err)
+			}
+		}(c)
+	}
+}
+
+func dial(ringSize uint32, req request) (*argo.Conn, error) {
+	d := argo.Dialer{RingSize: ringSize}
+
+	c, err := d.Dial(syscall.SOCK_STREAM, *domid, *port)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(c, binary.LittleEndian, &req); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// throughput measures how fast the server can fill a receive ring of
+// ringSize bytes with msgSize writes.
+func throughput(ringSize, msgSize uint32) (throughputResult, error) {
+	r := throughputResult{RingSize: ringSize, MsgSize: msgSize, Bytes: *total}
+
+	c, err := dial(ringSize, request{Mode: modeThroughput, MsgSize: msgSize, Count: *total})
+	if err != nil {
+		return r, err
+	}
+	defer c.Close()
+
+	buf := make([]byte, ringSize)
+	start := time.Now()
+	for recv := uint64(0); recv < *total; {
+		n, err := c.Read(buf)
+		if err != nil {
+			return r, err
+		}
+		recv += uint64(n)
+	}
+	elapsed := time.Since(start)
+
+	if _, err := c.Write([]byte{0}); err != nil {
+		return r, err
+	}
+
+	r.Seconds = elapsed.Seconds()
+	r.MBps = float64(r.Bytes) / r.Seconds / (1024 * 1024)
+
+	return r, nil
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	i := int(float64(len(sorted)-1) * p)
+	return float64(sorted[i]) / float64(time.Microsecond)
+}
+
+// latency measures round trip times of msgSize messages echoed by the server.
+func latency(msgSize uint32) (latencyResult, error) {
+	r := latencyResult{MsgSize: msgSize, Count: *count}
+
+	c, err := dial(0, request{Mode: modeLatency, MsgSize: msgSize, Count: *count})
+	if err != nil {
+		return r, err
+	}
+	defer c.Close()
+
+	buf := make([]byte, msgSize)
+	samples := make([]time.Duration, 0, *count)
+	for i := uint64(0); i < *count; i++ {
+		start := time.Now()
+		if _, err := c.Write(buf); err != nil {
+			return r, err
+		}
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return r, err
+		}
+		samples = append(samples, time.Since(start))
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	r.Min = percentile(samples, 0)
+	r.P50 = percentile(samples, 0.50)
+	r.P90 = percentile(samples, 0.90)
+	r.P99 = percentile(samples, 0.99)
+	r.Max = percentile(samples, 1)
+
+	return r, nil
+}
+
+// connectRate measures complete connection setup and teardown cycles.
+func connectRate() (connectResult, error) {
+	r := connectResult{Count: *connects}
+
+	b := make([]byte, 1)
+	start := time.Now()
+	for i := uint64(0); i < *connects; i++ {
+		c, err := dial(0, request{Mode: modeConnect})
+		if err != nil {
+			return r, err
+		}
+		_, err = c.Read(b)
+		c.Close()
+		if err != nil {
+			return r, err
+		}
+	}
+
+	r.Seconds = time.Since(start).Seconds()
+	r.PerSec = float64(r.Count) / r.Seconds
+
+	return r, nil
+}
+
+func run() (*results, error) {
+	res := &results{}
+
+	for _, t := range *tests {
+		switch t {
+		case "throughput":
+			for _, rs := range *ringSizes {
+				for _, ms := range *msgSizes {
+					r, err := throughput(uint32(rs), uint32(ms))
+					if err != nil {
+						return nil, fmt.Errorf("throughput ring %d msg %d: %v", rs, ms, err)
+					}
+					res.Throughput = append(res.Throughput, r)
+				}
+			}
+		case "latency":
+			for _, ms := range *msgSizes {
+				r, err := latency(uint32(ms))
+				if err != nil {
+					return nil, fmt.Errorf("latency msg %d: %v", ms, err)
+				}
+				res.Latency = append(res.Latency, r)
+			}
+		case "connect":
+			r, err := connectRate()
+			if err != nil {
+				return nil, fmt.Errorf("connect: %v", err)
+			}
+			res.Connect = &r
+		default:
+			return nil, fmt.Errorf("unknown test %s", t)
+		}
+	}
+
+	return res, nil
+}
+
+func report(res *results) {
+	if len(res.Throughput) > 0 {
+		fmt.Println("Throughput:")
+		fmt.Printf("  %10s %10s %12s %10s\n", "ring", "msg", "bytes", "MB/s")
+		for _, r := range res.Throughput {
+			fmt.Printf("  %10d %10d %12d %10.2f\n", r.RingSize, r.MsgSize, r.Bytes, r.MBps)
+		}
+	}
+
+	if len(res.Latency) > 0 {
+		fmt.Println("Latency (us):")
+		fmt.Printf("  %10s %10s %10s %10s %10s %10s %10s\n", "msg", "count", "min", "p50", "p90", "p99", "max")
+		for _, r := range res.Latency {
+			fmt.Printf("  %10d %10d %10.1f %10.1f %10.1f %10.1f %10.1f\n",
+				r.MsgSize, r.Count, r.Min, r.P50, r.P90, r.P99, r.Max)
+		}
+	}
+
+	if res.Connect != nil {
+		fmt.Println("Connection setup:")
+		fmt.Printf("  %d connections in %.2fs, %.1f/s\n",
+			res.Connect.Count, res.Connect.Seconds, res.Connect.PerSec)
+	}
+}
+
+func main() {
+
+	flag.Parse()
+
+	for _, ms := range *msgSizes {
+		if ms == 0 || ms > maxMsgSize {
+			die("invalid message size %d", ms)
+		}
+	}
+	for _, rs := range *ringSizes {
+		if rs == 0 || rs > math.MaxUint32 {
+			die("invalid ring size %d", rs)
+		}
+	}
+	if *count == 0 || *count > maxRoundTrips {
+		die("count must be between 1 and %d", maxRoundTrips)
+	}
+	if *total > maxBytes {
+		die("bytes must be at most %d", uint64(maxBytes))
+	}
+	if *connects == 0 {
+		die("connects must be greater than 0")
+	}
+
+	if *server {
+		listener(*port)
+		return
+	}
+
+	res, err := run()
+	if err != nil {
+		die("%v", err)
+	}
+
+	if *asJSON {
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			die("%v", err)
+		}
+		fmt.Println(string(b))
+	} else {
+		report(res)
+	}
+}
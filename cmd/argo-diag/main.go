@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/openxt/openxt-go/pkg/argo"
+	"github.com/openxt/openxt-go/pkg/xenstore"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	domid  = flag.IntP("domain", "d", -1, "local domain id (default read from xenstore)")
+	port   = flag.IntP("port", "p", 5999, "port used for the probe and loopback tests")
+	asJSON = flag.Bool("json", false, "report results as JSON")
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+	statusSkip = "skip"
+)
+
+type check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type report struct {
+	Domain      int      `json:"domain"`
+	Devices     []check  `json:"devices"`
+	Ioctls      []check  `json:"ioctls"`
+	MaxRingSize uint32   `json:"max_ring_size"`
+	RingSize    check    `json:"ring_size"`
+	Loopback    check    `json:"loopback"`
+	Viptables   check    `json:"viptables"`
+	Rules       []string `json:"viptables_rules"`
+}
+
+func newCheck(name string, err error) check {
+	if err != nil {
+		return check{Name: name, Status: statusFail, Detail: err.Error()}
+	}
+
+	return check{Name: name, Status: statusOK}
+}
+
+func localDomain() (int, error) {
+	xs, err := xenstore.NewClient(0)
+	if err != nil {
+		return 0, err
+	}
+	defer xs.Close()
+
+	s, err := xs.Read("domid")
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(s)
+}
+
+func checkDevices() []check {
+	var checks []check
+
+	for _, dev := range []string{"/dev/argo_stream", "/dev/argo_dgram"} {
+		fi, err := os.Stat(dev)
+		if err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+			err = fmt.Errorf("not a character device")
+		}
+		if err == nil {
+			var f *os.File
+			if f, err = os.OpenFile(dev, os.O_RDWR, 0); err == nil {
+				f.Close()
+			}
+		}
+		checks = append(checks, newCheck(dev, err))
+	}
+
+	return checks
+}
+
+func checkIoctls(domid, port int) []check {
+	var checks []check
+
+	for _, p := range argo.ProbeIoctls(argo.DomainId(domid), port) {
+		c := newCheck(p.Name, p.Err)
+		switch p.Err {
+		case argo.ErrNotProbed:
+			c.Status = statusSkip
+		case syscall.ENOTTY:
+			c.Detail = "request not supported by driver"
+		}
+		checks = append(checks, c)
+	}
+
+	return checks
+}
+
+// checkRingSize finds the largest receive ring the driver will register,
+// starting from the hypervisor maximum.
+func checkRingSize(port int) check {
+	var err error
+
+	for size := uint32(argo.XEN_ARGO_MAX_RING_SIZE); size >= 4096; size /= 2 {
+		lc := argo.ListenConfig{RingSize: size}
+
+		var l *argo.Listener
+		l, err = lc.Listen(syscall.SOCK_STREAM, port, argo.XEN_ARGO_DOMID_ANY)
+		if err == nil {
+			l.Close()
+			c := newCheck("ring size", nil)
+			c.Detail = fmt.Sprintf("largest ring registered %d bytes", size)
+			return c
+		}
+	}
+
+	return newCheck("ring size", err)
+}
+
+func checkLoopback(domid, port int) check {
+	msg := []byte("argo-diag loopback")
+
+	l, err := argo.Listen(syscall.SOCK_STREAM, port, argo.XEN_ARGO_DOMID_ANY)
+	if err != nil {
+		return newCheck("loopback", fmt.Errorf("listen: %v", err))
+	}
+	defer l.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		c, err := l.AcceptArgo()
+		if err != nil {
+			errc <- fmt.Errorf("accept: %v", err)
+			return
+		}
+		defer c.Close()
+
+		_, err = io.Copy(c, io.LimitReader(c, int64(len(msg))))
+		errc <- err
+	}()
+
+	start := time.Now()
+	c, err := argo.Dial(syscall.SOCK_STREAM, domid, port)
+	if err != nil {
+		return newCheck("loopback", fmt.Errorf("connect: %v", err))
+	}
+	defer c.Close()
+
+	if _, err := c.Write(msg); err != nil {
+		return newCheck("loopback", fmt.Errorf("write: %v", err))
+	}
+
+	b := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, b); err != nil {
+		return newCheck("loopback", fmt.Errorf("read: %v", err))
+	}
+	if err := <-errc; err != nil {
+		return newCheck("loopback", err)
+	}
+	if !bytes.Equal(b, msg) {
+		return newCheck("loopback", fmt.Errorf("echo mismatch"))
+	}
+
+	r := newCheck("loopback", nil)
+	r.Detail = fmt.Sprintf("echo via %d:%d in %v", domid, port, time.Since(start))
+	return r
+}
+
+func checkViptables() (check, []string) {
+	var rules []string
+
+	list, err := argo.ViptablesList()
+	for _, r := range list {
+		rules = append(rules, r.String())
+	}
+
+	c := newCheck("viptables", err)
+	if err == nil {
+		c.Detail = fmt.Sprintf("%d rules", len(rules))
+	}
+
+	return c, rules
+}
+
+func printCheck(c check) {
+	if c.Detail != "" {
+		fmt.Printf("  %-20s %-5s %s\n", c.Name, c.Status, c.Detail)
+	} else {
+		fmt.Printf("  %-20s %s\n", c.Name, c.Status)
+	}
+}
+
+func printReport(r *report) {
+	fmt.Printf("Domain: %d\n", r.Domain)
+
+	fmt.Println("Devices:")
+	for _, c := range r.Devices {
+		printCheck(c)
+	}
+
+	fmt.Println("Driver requests:")
+	for _, c := range r.Ioctls {
+		printCheck(c)
+	}
+
+	fmt.Println("Rings:")
+	fmt.Printf("  %-20s %d\n", "max ring size", r.MaxRingSize)
+	printCheck(r.RingSize)
+
+	fmt.Println("Connectivity:")
+	printCheck(r.Loopback)
+
+	fmt.Println("Firewall:")
+	printCheck(r.Viptables)
+	for i, rule := range r.Rules {
+		fmt.Printf("  %3d %s\n", i+1, rule)
+	}
+}
+
+func main() {
+
+	flag.Parse()
+
+	r := &report{
+		Domain:      *domid,
+		MaxRingSize: argo.XEN_ARGO_MAX_RING_SIZE,
+	}
+
+	if r.Domain < 0 {
+		d, err := localDomain()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "err: unable to determine local domain, use --domain: %v\n", err)
+			os.Exit(1)
+		}
+		r.Domain = d
+	}
+
+	r.Devices = checkDevices()
+	r.Ioctls = checkIoctls(r.Domain, *port)
+	r.RingSize = checkRingSize(*port)
+	r.Loopback = checkLoopback(r.Domain, *port)
+	r.Viptables, r.Rules = checkViptables()
+
+	if *asJSON {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "err: ", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(b))
+	} else {
+		printReport(r)
+	}
+}
@@ -1,63 +1,342 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"os/exec"
+	"sync"
 	"syscall"
+	"time"
 
-	flag "github.com/spf13/pflag"
 	"github.com/openxt/openxt-go/pkg/argo"
+	flag "github.com/spf13/pflag"
 )
 
 var (
-	domid = flag.IntP("domain", "d", 0, "destination domain id")
-	port = flag.IntP("port", "p", 5555, "destination port")
-	listen = flag.BoolP("listen", "l", false, "listen for incoming connections")
+	domid    = flag.IntP("domain", "d", 0, "destination domain id")
+	port     = flag.IntP("port", "p", 5555, "destination port")
+	listen   = flag.BoolP("listen", "l", false, "listen for incoming connections")
+	dgram    = flag.BoolP("udp", "u", false, "use datagram instead of stream sockets")
+	execProg = flag.StringP("exec", "e", "", "execute `program` with its stdin/stdout on the connection")
+	execCmd  = flag.StringP("sh-exec", "c", "", "execute `command` via /bin/sh with its stdin/stdout on the connection")
+	idle     = flag.IntP("wait", "w", 0, "close a connection after `secs` seconds of inactivity")
+	keep     = flag.BoolP("keep-open", "k", false, "keep listening and serve clients concurrently")
+	partner  = flag.Int("partner", argo.XEN_ARGO_DOMID_ANY, "only accept connections from this domain")
+	verbose  = flag.BoolP("verbose", "v", false, "print peer domain and port")
+	capFile  = flag.String("capture", "", "record connections to `file` in pcapng format")
 )
 
+var (
+	capture     *argo.Capture
+	captureFile *os.File
+)
+
+var errIdle = errors.New("idle timeout")
+
+// closeCapture flushes the capture file to disk, it must be called before
+// exiting since os.Exit skips deferred calls.
+func closeCapture() {
+	if captureFile == nil {
+		return
+	}
+
+	if err := captureFile.Sync(); err != nil {
+		fmt.Fprintln(os.Stderr, "err: capture file: ", err)
+	}
+	if err := captureFile.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "err: capture file: ", err)
+	}
+	captureFile = nil
+}
+
+func die(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "err: "+format, a...)
+	fmt.Fprintln(os.Stderr)
+	closeCapture()
+	os.Exit(1)
+}
+
+func logf(format string, a ...interface{}) {
+	if *verbose {
+		fmt.Fprintf(os.Stderr, format, a...)
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// idleConn closes the underlying connection and reports errIdle once no
+// data has moved in either direction for the timeout duration.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+func newIdleConn(c net.Conn, timeout time.Duration) *idleConn {
+	ic := &idleConn{
+		Conn:    c,
+		timeout: timeout,
+		expired: make(chan struct{}),
+	}
+	ic.timer = time.AfterFunc(timeout, func() {
+		close(ic.expired)
+		ic.Conn.Close()
+	})
+
+	return ic
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.timer.Reset(c.timeout)
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.timer.Reset(c.timeout)
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// transfer copies between the connection and stdin/stdout until the peer
+// closes its side. argo has no half-close, so when stdin reaches EOF the
+// connection is left open for the peer's response.
+func transfer(c net.Conn, in io.Reader, out io.Writer) error {
+	if in != nil {
+		go func() {
+			if _, err := io.Copy(c, in); err != nil {
+				fmt.Fprintln(os.Stderr, "err: argo connection error: ", err)
+			}
+		}()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, c)
+		done <- err
+	}()
+
+	var expired chan struct{}
+	if ic, ok := c.(*idleConn); ok {
+		expired = ic.expired
+	}
+
+	select {
+	case err := <-done:
+		switch err {
+		case nil, io.EOF, io.ErrClosedPipe:
+			return nil
+		default:
+			return err
+		}
+	case <-expired:
+		// The timer has closed the connection, which unblocks the read,
+		// so the copy finishes writing what it has before returning
+		<-done
+		return errIdle
+	}
+}
+
+func execute(c net.Conn) error {
+	var cmd *exec.Cmd
+
+	if *execCmd != "" {
+		cmd = exec.Command("/bin/sh", "-c", *execCmd)
+	} else {
+		cmd = exec.Command(*execProg)
+	}
+	cmd.Stdin = c
+	cmd.Stdout = c
+	cmd.Stderr = os.Stderr
+
+	ic, ok := c.(*idleConn)
+	if !ok {
+		return cmd.Run()
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ic.expired:
+		// The program may be idle without its stdin being read, so the
+		// timeout is enforced by killing it. Wait is not waited for as it
+		// also waits on the copy from the connection.
+		cmd.Process.Kill()
+		return errIdle
+	}
+}
+
+func handle(c net.Conn, in io.Reader, out io.Writer) error {
+	if capture != nil {
+		c = capture.Conn(c)
+	}
+
+	return serve(c, in, out)
+}
+
+// serve handles an accepted connection, which the capture listener has
+// already wrapped.
+func serve(c net.Conn, in io.Reader, out io.Writer) error {
+	if *idle > 0 {
+		c = newIdleConn(c, time.Duration(*idle)*time.Second)
+	}
+	defer c.Close()
+
+	if *execProg != "" || *execCmd != "" {
+		return execute(c)
+	}
+
+	return transfer(c, in, out)
+}
+
 func sender(sockType, domid, port int) {
-	s, err := argo.Dial(sockType, domid, port)
+	c, err := argo.Dial(sockType, domid, port)
 	if err != nil {
-		panic(err)
+		die("argo connect to %d:%d failed: %v", domid, port, err)
+	}
+
+	logf("connected to %v from %v", c.RemoteAddr(), c.LocalAddr())
+
+	if err := handle(c, os.Stdin, os.Stdout); err != nil {
+		die("argo connection error: %v", err)
 	}
+}
 
-	_, err = io.Copy(s, os.Stdin)
+func datagramListener(port int) {
+	c, err := argo.ListenDatagram(port, argo.DomainId(*partner))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "err: argo connection error: ", err)
+		die("argo bind of port %d failed: %v", port, err)
+	}
+
+	logf("listening for datagrams on %v", c.LocalAddr())
+
+	// Received datagrams carry no return address so there is nothing to
+	// send stdin to
+	if err := handle(c, nil, os.Stdout); err != nil {
+		die("argo connection error: %v", err)
 	}
 }
 
 func listener(sockType, port int) {
-	l, err := argo.Listen(sockType, port, argo.XEN_ARGO_DOMID_ANY)
+	if sockType == syscall.SOCK_DGRAM {
+		datagramListener(port)
+		return
+	}
+
+	al, err := argo.Listen(sockType, port, argo.DomainId(*partner))
 	if err != nil {
-		panic(err)
+		die("argo listen on port %d failed: %v", port, err)
 	}
+	defer al.Close()
+
+	var l net.Listener = al
+	if capture != nil {
+		l = capture.Listener(al)
+	}
+
+	logf("listening on %v", l.Addr())
+
+	if !*keep {
+		c, err := l.Accept()
+		if err != nil {
+			die("argo accept error: %v", err)
+		}
+
+		logf("connection from %v", c.RemoteAddr())
+
+		if err := serve(c, os.Stdin, os.Stdout); err != nil {
+			die("argo connection error: %v", err)
+		}
+		return
+	}
+
+	// Concurrent clients share stdout, stdin is not forwarded since it
+	// cannot be split between them.
+	var mu sync.Mutex
+	out := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return os.Stdout.Write(p)
+	})
 
 	for {
-		incoming, err := l.Accept()
+		c, err := l.Accept()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "err: argo listen error: ", err)
-		} else {
-			_, err := io.Copy(os.Stdout, incoming)
-			switch err {
-			case io.ErrClosedPipe,io.EOF:
-				continue
-			default:
-				fmt.Fprintln(os.Stderr, "err: argo connection error: ", err)
-			}
-			incoming.Close()
+			fmt.Fprintln(os.Stderr, "err: argo accept error: ", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
 		}
+
+		logf("connection from %v", c.RemoteAddr())
+
+		go func(c net.Conn) {
+			if err := serve(c, nil, out); err != nil {
+				fmt.Fprintf(os.Stderr, "err: %v: argo connection error: %v\n", c.RemoteAddr(), err)
+			}
+			logf("connection from %v closed", c.RemoteAddr())
+		}(c)
 	}
 }
 
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
 func main() {
 
 	flag.Parse()
 
+	if *execProg != "" && *execCmd != "" {
+		die("-e and -c are mutually exclusive")
+	}
+
+	sockType := syscall.SOCK_STREAM
+	if *dgram {
+		sockType = syscall.SOCK_DGRAM
+		if *execProg != "" || *execCmd != "" {
+			die("-e and -c are not supported with datagrams")
+		}
+		if *keep {
+			die("-k is not supported with datagrams")
+		}
+	}
+
+	if *capFile != "" {
+		f, err := os.Create(*capFile)
+		if err != nil {
+			die("unable to create capture file: %v", err)
+		}
+		captureFile = f
+
+		if capture, err = argo.NewCapture(f); err != nil {
+			die("unable to start capture: %v", err)
+		}
+	}
+
 	if *listen {
-		listener(syscall.SOCK_STREAM, *port)
+		listener(sockType, *port)
 	} else {
-		sender(syscall.SOCK_STREAM, *domid, *port)
+		sender(sockType, *domid, *port)
 	}
+
+	closeCapture()
 }
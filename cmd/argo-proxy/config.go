@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+// Config file format, one forwarding rule per line:
+//
+//   <listen> <connect> [allow <domain>[,<domain>...]]
+//
+// where an endpoint is one of:
+//
+//   argo:<port>            listen on an argo port (listen side only)
+//   argo:<domain>:<port>   connect to an argo port (connect side only)
+//   tcp:<host>:<port>      listen on or connect to a TCP address
+//   unix:<path>            listen on or connect to a Unix socket
+//
+// The allow list restricts the source domains accepted by an argo listener.
+// Blank lines and lines starting with '#' are ignored.
+
+type endpoint struct {
+	network string
+	address string
+	domain  argo.DomainId
+	port    int
+}
+
+type rule struct {
+	line    int
+	listen  endpoint
+	connect endpoint
+	allow   []argo.DomainId
+}
+
+func (e endpoint) String() string {
+	return e.network + ":" + e.address
+}
+
+func parseEndpoint(s string, listen bool) (endpoint, error) {
+	i := strings.IndexRune(s, ':')
+	if i == -1 {
+		return endpoint{}, fmt.Errorf("invalid endpoint %s (no transport)", s)
+	}
+
+	e := endpoint{network: s[:i], address: s[i+1:]}
+
+	switch e.network {
+	case "argo":
+		f := strings.Split(e.address, ":")
+		switch {
+		case listen && len(f) == 1:
+			port, err := strconv.ParseUint(f[0], 10, 32)
+			if err != nil {
+				return e, fmt.Errorf("invalid argo port %s", f[0])
+			}
+			e.port = int(port)
+		case !listen && len(f) == 2:
+			domid, err := strconv.ParseUint(f[0], 10, 16)
+			if err != nil {
+				return e, fmt.Errorf("invalid argo domain %s", f[0])
+			}
+			port, err := strconv.ParseUint(f[1], 10, 32)
+			if err != nil {
+				return e, fmt.Errorf("invalid argo port %s", f[1])
+			}
+			e.domain, e.port = argo.DomainId(domid), int(port)
+		case listen:
+			return e, fmt.Errorf("invalid argo listen endpoint %s, expected argo:<port>", s)
+		default:
+			return e, fmt.Errorf("invalid argo connect endpoint %s, expected argo:<domain>:<port>", s)
+		}
+	case "tcp":
+		if _, _, err := net.SplitHostPort(e.address); err != nil {
+			return e, err
+		}
+	case "unix":
+		if e.address == "" {
+			return e, fmt.Errorf("invalid unix endpoint %s (no path)", s)
+		}
+	default:
+		return e, fmt.Errorf("invalid endpoint %s (unsupported transport)", s)
+	}
+
+	return e, nil
+}
+
+func parseAllow(s string) ([]argo.DomainId, error) {
+	var domains []argo.DomainId
+
+	for _, f := range strings.Split(s, ",") {
+		domid, err := strconv.ParseUint(f, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain %s", f)
+		}
+		domains = append(domains, argo.DomainId(domid))
+	}
+
+	return domains, nil
+}
+
+func parseRule(line string) (*rule, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 4 {
+		return nil, fmt.Errorf("expected <listen> <connect> [allow <domains>]")
+	}
+
+	var err error
+	r := &rule{}
+
+	if r.listen, err = parseEndpoint(fields[0], true); err != nil {
+		return nil, err
+	}
+	if r.connect, err = parseEndpoint(fields[1], false); err != nil {
+		return nil, err
+	}
+	if r.listen.network == "argo" && r.connect.network == "argo" {
+		return nil, fmt.Errorf("argo to argo forwarding is not supported")
+	}
+	if r.listen.network != "argo" && r.connect.network != "argo" {
+		return nil, fmt.Errorf("one side of a rule must be argo")
+	}
+
+	if len(fields) == 4 {
+		if fields[2] != "allow" {
+			return nil, fmt.Errorf("unknown option %s", fields[2])
+		}
+		if r.listen.network != "argo" {
+			return nil, fmt.Errorf("allow is only valid for argo listeners")
+		}
+		if r.allow, err = parseAllow(fields[3]); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func parseConfig(r io.Reader) ([]*rule, error) {
+	var rules []*rule
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		r.line = n
+		rules = append(rules, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func loadConfig(path string) ([]*rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseConfig(f)
+}
+
+// allowed reports whether a connection from the given domain is permitted.
+func (r *rule) allowed(domid argo.DomainId) bool {
+	if len(r.allow) == 0 {
+		return true
+	}
+
+	for _, d := range r.allow {
+		if d == domid {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *rule) listenSock() (net.Listener, error) {
+	switch r.listen.network {
+	case "argo":
+		// When a single domain is allowed let the driver do the filtering
+		partner := argo.DomainId(argo.XEN_ARGO_DOMID_ANY)
+		if len(r.allow) == 1 {
+			partner = r.allow[0]
+		}
+		l, err := argo.Listen(syscall.SOCK_STREAM, r.listen.port, partner)
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	case "unix":
+		os.Remove(r.listen.address)
+		fallthrough
+	default:
+		return net.Listen(r.listen.network, r.listen.address)
+	}
+}
+
+func (r *rule) dial() (net.Conn, error) {
+	switch r.connect.network {
+	case "argo":
+		c, err := argo.Dial(syscall.SOCK_STREAM, int(r.connect.domain), r.connect.port)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	default:
+		return net.Dial(r.connect.network, r.connect.address)
+	}
+}
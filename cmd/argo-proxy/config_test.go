@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		line    string
+		err     string
+		listen  endpoint
+		connect endpoint
+		allow   []argo.DomainId
+	}{
+		{
+			line:    "argo:5555 tcp:127.0.0.1:80",
+			listen:  endpoint{network: "argo", address: "5555", port: 5555},
+			connect: endpoint{network: "tcp", address: "127.0.0.1:80"},
+		},
+		{
+			line:    "unix:/run/x.sock argo:0:5556",
+			listen:  endpoint{network: "unix", address: "/run/x.sock"},
+			connect: endpoint{network: "argo", address: "0:5556", domain: 0, port: 5556},
+		},
+		{
+			line:    "argo:80 unix:/run/web allow 1,7",
+			listen:  endpoint{network: "argo", address: "80", port: 80},
+			connect: endpoint{network: "unix", address: "/run/web"},
+			allow:   []argo.DomainId{1, 7},
+		},
+		{line: "argo:80", err: "expected <listen> <connect>"},
+		{line: "argo:80 tcp:h:1 allow", err: "expected <listen> <connect>"},
+		{line: "argo:80 tcp:h:1 deny 1", err: "unknown option deny"},
+		{line: "tcp::80 argo:0:80 allow 1", err: "only valid for argo listeners"},
+		{line: "argo:80 argo:0:80", err: "argo to argo"},
+		{line: "tcp::80 unix:/x", err: "must be argo"},
+		{line: "argo:0:80 tcp:h:1", err: "expected argo:<port>"},
+		{line: "tcp::80 argo:80", err: "expected argo:<domain>:<port>"},
+		{line: "argo:x tcp:h:1", err: "invalid argo port"},
+		{line: "tcp::80 argo:70000:1", err: "invalid argo domain"},
+		{line: "argo:80 tcp:nohost", err: "missing port"},
+		{line: "argo:80 unix:", err: "no path"},
+		{line: "argo:80 udp:h:1", err: "unsupported transport"},
+		{line: "argo:80 tcp:h:1 allow 1,x", err: "invalid domain x"},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRule(tt.line)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if r.listen != tt.listen || r.connect != tt.connect {
+			t.Errorf("%q: got %+v -> %+v", tt.line, r.listen, r.connect)
+		}
+		if len(r.allow) != len(tt.allow) {
+			t.Errorf("%q: allow %v, want %v", tt.line, r.allow, tt.allow)
+			continue
+		}
+		for i := range r.allow {
+			if r.allow[i] != tt.allow[i] {
+				t.Errorf("%q: allow %v, want %v", tt.line, r.allow, tt.allow)
+			}
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config := `# forwarding rules
+
+argo:5555 tcp:127.0.0.1:80
+  argo:5556 unix:/run/x allow 3
+`
+	rules, err := parseConfig(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].line != 3 || rules[1].line != 4 {
+		t.Fatalf("got %d rules", len(rules))
+	}
+	if !rules[1].allowed(3) || rules[1].allowed(4) || !rules[0].allowed(4) {
+		t.Error("allow list not applied")
+	}
+
+	_, err = parseConfig(strings.NewReader("argo:1 tcp:h:1\nbogus\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("got error %v, want line 2", err)
+	}
+}
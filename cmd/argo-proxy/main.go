@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/openxt/openxt-go/pkg/argo"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	config  = flag.StringP("config", "c", "/etc/argo-proxy.conf", "forwarding rules configuration file")
+	verbose = flag.BoolP("verbose", "v", false, "log connection byte counts on close")
+)
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// pipe copies src to dst, half-closing dst when src reaches EOF if the
+// transport allows it.
+func pipe(dst, src net.Conn, done chan<- int64) {
+	n, _ := io.Copy(dst, src)
+	if cw, ok := dst.(closeWriter); ok {
+		cw.CloseWrite()
+	}
+	done <- n
+}
+
+func forward(r *rule, id int, in net.Conn) {
+	defer in.Close()
+
+	peer := in.RemoteAddr().String()
+	if r.listen.network == "argo" {
+		domid := in.RemoteAddr().(argo.Addr).Domain
+		if !r.allowed(domid) {
+			log.Printf("rule %d: [%d] rejected %s: domain %d not allowed", r.line, id, peer, domid)
+			return
+		}
+	}
+
+	out, err := r.dial()
+	if err != nil {
+		log.Printf("rule %d: [%d] %s -> %s: connect error: %v", r.line, id, peer, r.connect, err)
+		return
+	}
+	defer out.Close()
+
+	log.Printf("rule %d: [%d] %s -> %s: connected", r.line, id, peer, r.connect)
+
+	sent := make(chan int64, 1)
+	recv := make(chan int64, 1)
+	go pipe(out, in, sent)
+	go pipe(in, out, recv)
+
+	// argo has no half-close, so once either direction finishes and the
+	// other side cannot be half-closed tear down the whole connection.
+	var tx, rx int64
+	select {
+	case tx = <-sent:
+		if _, ok := out.(closeWriter); !ok {
+			out.Close()
+			in.Close()
+		}
+		rx = <-recv
+	case rx = <-recv:
+		if _, ok := in.(closeWriter); !ok {
+			in.Close()
+			out.Close()
+		}
+		tx = <-sent
+	}
+
+	if *verbose {
+		log.Printf("rule %d: [%d] %s -> %s: closed, sent %d bytes, received %d bytes",
+			r.line, id, peer, r.connect, tx, rx)
+	} else {
+		log.Printf("rule %d: [%d] %s -> %s: closed", r.line, id, peer, r.connect)
+	}
+}
+
+func serve(r *rule, l net.Listener, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	log.Printf("rule %d: forwarding %s -> %s", r.line, r.listen, r.connect)
+
+	for id := 1; ; id++ {
+		c, err := l.Accept()
+		if err != nil {
+			// argo reports accept failures as plain errors, so back
+			// off and keep serving rather than giving up the rule
+			log.Printf("rule %d: accept error: %v", r.line, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		go forward(r, id, c)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	rules, err := loadConfig(*config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "err: %s: %v\n", *config, err)
+		os.Exit(1)
+	}
+	if len(rules) == 0 {
+		fmt.Fprintf(os.Stderr, "err: %s: no forwarding rules\n", *config)
+		os.Exit(1)
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range rules {
+		l, err := r.listenSock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "err: rule %d: listen on %s: %v\n", r.line, r.listen, err)
+			os.Exit(1)
+		}
+
+		wg.Add(1)
+		go serve(r, l, &wg)
+	}
+
+	wg.Wait()
+}
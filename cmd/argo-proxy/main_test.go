@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+// tcpRule returns an argo to TCP rule forwarding to a server running
+// handle on each connection.
+func tcpRule(t *testing.T, allow []argo.DomainId, handle func(*net.TCPConn)) (*rule, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c.(*net.TCPConn))
+			}()
+		}
+	}()
+
+	r := &rule{
+		line:    1,
+		listen:  endpoint{network: "argo", address: "5555", port: 5555},
+		connect: endpoint{network: "tcp", address: l.Addr().String()},
+		allow:   allow,
+	}
+
+	return r, func() { l.Close() }
+}
+
+func forwarded(t *testing.T, r *rule, from argo.DomainId) (net.Conn, <-chan struct{}) {
+	client, in := argo.Pipe(argo.Addr{Domain: from, Port: 1000}, argo.Addr{Domain: 0, Port: 5555})
+
+	done := make(chan struct{})
+	go func() {
+		forward(r, 1, in)
+		close(done)
+	}()
+
+	return client, done
+}
+
+func wait(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("forward did not return")
+	}
+}
+
+func TestForwardHalfClose(t *testing.T) {
+	// The server reads a request and half-closes after replying, the argo
+	// side cannot be half-closed so the proxy must tear it down
+	r, stop := tcpRule(t, nil, func(c *net.TCPConn) {
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return
+		}
+		c.Write([]byte("pong"))
+		c.CloseWrite()
+		ioutil.ReadAll(c)
+	})
+	defer stop()
+
+	client, done := forwarded(t, r, 1)
+	defer client.Close()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "pong" {
+		t.Errorf("got %q", b)
+	}
+
+	wait(t, done)
+}
+
+func TestForwardGuestClose(t *testing.T) {
+	got := make(chan string, 1)
+	r, stop := tcpRule(t, nil, func(c *net.TCPConn) {
+		b, _ := ioutil.ReadAll(c)
+		got <- string(b)
+	})
+	defer stop()
+
+	client, done := forwarded(t, r, 1)
+	client.Write([]byte("request"))
+	client.Close()
+
+	// Closing the guest end half-closes the TCP side, so the server sees
+	// EOF after the data
+	select {
+	case s := <-got:
+		if s != "request" {
+			t.Errorf("server got %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not see EOF")
+	}
+
+	wait(t, done)
+}
+
+func TestForwardRejected(t *testing.T) {
+	r, stop := tcpRule(t, []argo.DomainId{2}, func(c *net.TCPConn) {
+		t.Error("rejected domain was forwarded")
+	})
+	defer stop()
+
+	client, done := forwarded(t, r, 1)
+	defer client.Close()
+
+	wait(t, done)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF && err != io.ErrClosedPipe {
+		t.Errorf("read from rejected connection: %v", err)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// generator writes a client package for a set of interfaces in the style
+// of pkg/dbd: a Client interface that can be mocked and a struct type
+// implementing it over a *dbus.Conn.
+type generator struct {
+	pkg    string
+	source string
+	dest   string
+	path   string
+	typ    string
+	buf    bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+type arg struct {
+	name string
+	typ  string
+}
+
+func (g *generator) args(args []introspect.Arg, direction, prefix string) ([]arg, error) {
+	var out []arg
+
+	for i, a := range args {
+		dir := a.Direction
+		if dir == "" {
+			dir = "in"
+		}
+		if direction != "" && dir != direction {
+			continue
+		}
+
+		t, err := goType(a.Type)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %v", a.Name, err)
+		}
+		out = append(out, arg{name: localName(a.Name, fmt.Sprintf("%s%d", prefix, i)), typ: t})
+	}
+
+	// Give repeated names a suffix, in and out arguments share a scope
+	seen := make(map[string]int)
+	for i := range out {
+		seen[out[i].name]++
+		if n := seen[out[i].name]; n > 1 {
+			out[i].name = fmt.Sprintf("%s%d", out[i].name, n)
+		}
+	}
+
+	return out, nil
+}
+
+func xmlArgs(g *generator, args []introspect.Arg, withDirection bool) {
+	for _, a := range args {
+		g.printf("//      <arg")
+		if a.Name != "" {
+			g.printf(" name=%q", a.Name)
+		}
+		g.printf(" type=%q", a.Type)
+		if withDirection {
+			dir := a.Direction
+			if dir == "" {
+				dir = "in"
+			}
+			g.printf(" direction=%q", dir)
+		}
+		g.printf("/>\n")
+	}
+}
+
+// typeNames picks the Go type for each interface, the last element of its
+// name unless given on the command line. Interfaces sharing a last element
+// are qualified by as many of the preceding elements as it takes to tell
+// them apart, e.g. a.b.Foo and c.d.Foo become BFoo and DFoo.
+func (g *generator) typeNames(ifaces []introspect.Interface) (map[string]string, error) {
+	names := make(map[string]string)
+	if len(ifaces) == 1 && g.typ != "" {
+		names[ifaces[0].Name] = g.typ
+		return names, nil
+	}
+
+	elems := make(map[string]int)
+	for _, iface := range ifaces {
+		elems[iface.Name] = 1
+	}
+
+	for {
+		byType := make(map[string][]string)
+		for _, iface := range ifaces {
+			parts := strings.Split(iface.Name, ".")
+			typ := exportedName(strings.Join(parts[len(parts)-elems[iface.Name]:], "."))
+			names[iface.Name] = typ
+			byType[typ] = append(byType[typ], iface.Name)
+		}
+
+		clash := false
+		for typ, same := range byType {
+			if len(same) == 1 {
+				continue
+			}
+			clash = true
+			for _, name := range same {
+				if elems[name] == strings.Count(name, ".")+1 {
+					sort.Strings(same)
+					return nil, fmt.Errorf("interfaces %s all map to Go type %s", strings.Join(same, ", "), typ)
+				}
+				elems[name]++
+			}
+		}
+		if !clash {
+			return names, nil
+		}
+	}
+}
+
+// checkDecls rejects generated code declaring a name twice, as when a
+// signal type of one interface takes the name of another interface.
+func checkDecls(f *ast.File) error {
+	seen := make(map[string]bool)
+	declare := func(name string) error {
+		if seen[name] {
+			return fmt.Errorf("%s is declared twice", name)
+		}
+		seen[name] = true
+		return nil
+	}
+
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil {
+				continue
+			}
+			if err := declare(d.Name.Name); err != nil {
+				return err
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if err := declare(spec.Name.Name); err != nil {
+						return err
+					}
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						if err := declare(n.Name); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+type member struct {
+	sig  string
+	body func()
+}
+
+func (g *generator) generate(ifaces []introspect.Interface) ([]byte, error) {
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+
+	g.printf("// Code generated by dbus-codegen from %s. DO NOT EDIT.\n\n", g.source)
+	g.printf("package %s\n\n", g.pkg)
+	hasSignals := false
+	for _, iface := range ifaces {
+		hasSignals = hasSignals || len(iface.Signals) > 0
+	}
+	if hasSignals {
+		g.printf("import (\n\t\"sync\"\n\n\t\"github.com/godbus/dbus/v5\"\n)\n\n")
+	} else {
+		g.printf("import (\n\t\"github.com/godbus/dbus/v5\"\n)\n\n")
+	}
+
+	if g.dest != "" {
+		g.printf("const Destination = %q\n\n", g.dest)
+	}
+
+	types, err := g.typeNames(ifaces)
+	if err != nil {
+		return nil, err
+	}
+
+	single := len(ifaces) == 1
+	for _, iface := range ifaces {
+		if err := g.iface(iface, types[iface.Name], single); err != nil {
+			return nil, fmt.Errorf("%s: %v", iface.Name, err)
+		}
+	}
+
+	if hasSignals {
+		g.watchHelper()
+	}
+
+	// The output is written already formatted rather than passed through
+	// go/format, which would reflow the XML in the doc comments
+	src := g.buf.Bytes()
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.AllErrors)
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %v", err)
+	}
+	if err := checkDecls(f); err != nil {
+		return nil, fmt.Errorf("generated invalid code: %v", err)
+	}
+
+	return src, nil
+}
+
+func (g *generator) iface(iface introspect.Interface, typ string, single bool) error {
+	client := typ + "Client"
+	if single {
+		client = "Client"
+	}
+
+	used := make(map[string]bool)
+	unique := func(name, suffix string) string {
+		if used[name] {
+			name += suffix
+		}
+		used[name] = true
+		return name
+	}
+
+	var members []member
+
+	for _, m := range iface.Methods {
+		m := m
+		name := unique(exportedName(m.Name), "Method")
+
+		in, err := g.args(m.Args, "in", "arg")
+		if err != nil {
+			return fmt.Errorf("method %s: %v", m.Name, err)
+		}
+		out, err := g.args(m.Args, "out", "out")
+		if err != nil {
+			return fmt.Errorf("method %s: %v", m.Name, err)
+		}
+		for i := range out {
+			for _, a := range in {
+				if out[i].name == a.name {
+					out[i].name += "Out"
+				}
+			}
+		}
+
+		var params, results, callArgs, stores, returns []string
+		for _, a := range in {
+			params = append(params, a.name+" "+a.typ)
+			callArgs = append(callArgs, ", "+a.name)
+		}
+		for _, a := range out {
+			results = append(results, a.typ)
+			stores = append(stores, "&"+a.name)
+			returns = append(returns, a.name)
+		}
+		results = append(results, "error")
+
+		sig := fmt.Sprintf("%s(%s) ", name, strings.Join(params, ", "))
+		if len(results) == 1 {
+			sig += "error"
+		} else {
+			sig += "(" + strings.Join(results, ", ") + ")"
+		}
+
+		members = append(members, member{sig: sig, body: func() {
+			if len(m.Args) == 0 {
+				g.printf("//    <method name=%q/>\n", m.Name)
+			} else {
+				g.printf("//    <method name=%q>\n", m.Name)
+				xmlArgs(g, m.Args, true)
+				g.printf("//    </method>\n")
+			}
+			g.printf("func (c *%s) %s {\n", typ, sig)
+			g.printf("\tobj := c.conn.Object(c.dest, c.path)\n\n")
+			if len(out) == 0 {
+				g.printf("\tcall := obj.Call(%q, 0%s)\n\n", iface.Name+"."+m.Name, strings.Join(callArgs, ""))
+				g.printf("\treturn call.Err\n}\n\n")
+				return
+			}
+			for _, a := range out {
+				g.printf("\tvar %s %s\n", a.name, a.typ)
+			}
+			g.printf("\terr := obj.Call(%q, 0%s).Store(%s)\n\n",
+				iface.Name+"."+m.Name, strings.Join(callArgs, ""), strings.Join(stores, ", "))
+			g.printf("\treturn %s, err\n}\n\n", strings.Join(returns, ", "))
+		}})
+	}
+
+	for _, p := range iface.Properties {
+		p := p
+		t, err := goType(p.Type)
+		if err != nil {
+			return fmt.Errorf("property %s: %v", p.Name, err)
+		}
+
+		comment := fmt.Sprintf("//    <property name=%q type=%q access=%q/>\n", p.Name, p.Type, p.Access)
+
+		if p.Access != "write" {
+			name := unique("Get"+exportedName(p.Name), "Property")
+			sig := fmt.Sprintf("%s() (%s, error)", name, t)
+			members = append(members, member{sig: sig, body: func() {
+				g.printf("%s", comment)
+				g.printf("func (c *%s) %s {\n", typ, sig)
+				g.printf("\tobj := c.conn.Object(c.dest, c.path)\n\n")
+				g.printf("\tvar p %s\n", t)
+				g.printf("\tv, err := obj.GetProperty(%q)\n", iface.Name+"."+p.Name)
+				g.printf("\tif err != nil {\n\t\treturn p, err\n\t}\n")
+				g.printf("\terr = dbus.Store([]interface{}{v.Value()}, &p)\n\n")
+				g.printf("\treturn p, err\n}\n\n")
+			}})
+		}
+
+		if p.Access == "write" || p.Access == "readwrite" {
+			name := unique("Set"+exportedName(p.Name), "Property")
+			sig := fmt.Sprintf("%s(value %s) error", name, t)
+			members = append(members, member{sig: sig, body: func() {
+				g.printf("%s", comment)
+				g.printf("func (c *%s) %s {\n", typ, sig)
+				g.printf("\tobj := c.conn.Object(c.dest, c.path)\n\n")
+				g.printf("\treturn obj.SetProperty(%q, dbus.MakeVariant(value))\n}\n\n", iface.Name+"."+p.Name)
+			}})
+		}
+	}
+
+	for _, s := range iface.Signals {
+		s := s
+		args, err := g.args(s.Args, "", "arg")
+		if err != nil {
+			return fmt.Errorf("signal %s: %v", s.Name, err)
+		}
+
+		sigType := typ + exportedName(s.Name)
+		name := unique("Watch"+exportedName(s.Name), "Signal")
+		sig := fmt.Sprintf("%s(ch chan<- *%s) (func() error, error)", name, sigType)
+
+		members = append(members, member{sig: sig, body: func() {
+			g.printf("// %s carries the arguments of the %s signal.\n", sigType, s.Name)
+			g.printf("type %s struct {\n", sigType)
+			width := 0
+			for _, a := range args {
+				if n := len(exportedName(a.name)); n > width {
+					width = n
+				}
+			}
+			for _, a := range args {
+				g.printf("\t%-*s %s\n", width, exportedName(a.name), a.typ)
+			}
+			g.printf("}\n\n")
+
+			if len(s.Args) == 0 {
+				g.printf("//    <signal name=%q/>\n", s.Name)
+			} else {
+				g.printf("//    <signal name=%q>\n", s.Name)
+				xmlArgs(g, s.Args, false)
+				g.printf("//    </signal>\n")
+			}
+			g.printf("func (c *%s) %s {\n", typ, sig)
+			g.printf("\treturn watchSignal(c.conn, c.path, %q, %q, func(sig *dbus.Signal, done <-chan struct{}) {\n", iface.Name, s.Name)
+			g.printf("\t\tvar s %s\n", sigType)
+			var fields []string
+			for _, a := range args {
+				fields = append(fields, "&s."+exportedName(a.name))
+			}
+			if len(fields) > 0 {
+				g.printf("\t\tif err := dbus.Store(sig.Body, %s); err != nil {\n\t\t\treturn\n\t\t}\n", strings.Join(fields, ", "))
+			}
+			g.printf("\t\tselect {\n\t\tcase ch <- &s:\n\t\tcase <-done:\n\t\t}\n\t})\n}\n\n")
+		}})
+	}
+
+	g.printf("type %s interface {\n", client)
+	for _, m := range members {
+		g.printf("\t%s\n", m.sig)
+	}
+	g.printf("}\n\n")
+
+	g.printf("type %s struct {\n\tconn *dbus.Conn\n\tdest string\n\tpath dbus.ObjectPath\n}\n\n", typ)
+	g.printf("func New%s(conn *dbus.Conn, dest string, path dbus.ObjectPath) *%s {\n", typ, typ)
+	g.printf("\treturn &%s{\n\t\tconn: conn,\n\t\tdest: dest,\n\t\tpath: path,\n\t}\n}\n\n", typ)
+
+	if single && g.dest != "" {
+		g.printf("func NewClient(conn *dbus.Conn) %s {\n", client)
+		g.printf("\treturn New%s(conn, Destination, %q)\n}\n\n", typ, g.path)
+	}
+
+	for _, m := range members {
+		m.body()
+	}
+
+	return nil
+}
+
+// watchHelper writes the subscription code shared by all Watch methods.
+func (g *generator) watchHelper() {
+	g.printf(`// watchSignal adds a match for member of iface on path and calls deliver
+// with each matching signal until the returned function is called, which
+// closes done.
+func watchSignal(conn *dbus.Conn, path dbus.ObjectPath, iface, member string, deliver func(*dbus.Signal, <-chan struct{})) (func() error, error) {
+	match := []dbus.MatchOption{
+		dbus.WithMatchInterface(iface),
+		dbus.WithMatchMember(member),
+		dbus.WithMatchObjectPath(path),
+	}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return nil, err
+	}
+
+	sigs := make(chan *dbus.Signal, 16)
+	conn.Signal(sigs)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig, ok := <-sigs:
+				if !ok {
+					return
+				}
+				if sig.Path == path && sig.Name == iface+"."+member {
+					deliver(sig, done)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() {
+			close(done)
+			conn.RemoveSignal(sigs)
+			err = conn.RemoveMatchSignal(match...)
+		})
+		return err
+	}, nil
+}
+`)
+}
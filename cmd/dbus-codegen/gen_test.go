@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// generateFile runs the generator over an introspection file as main does
+// for the interfaces it finds.
+func generateFile(t *testing.T, g *generator, file string) ([]byte, error) {
+	n, err := readNode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := make(map[string]introspect.Interface)
+	collect(n, all)
+
+	var ifaces []introspect.Interface
+	for _, iface := range all {
+		ifaces = append(ifaces, iface)
+	}
+
+	return g.generate(ifaces)
+}
+
+func TestGenerateGolden(t *testing.T) {
+	tests := []struct {
+		xml string
+		gen *generator
+	}{
+		{"single.xml", &generator{pkg: "db", source: "single.xml", dest: "com.citrix.xenclient.db", path: "/", typ: "Dbd"}},
+		{"multi.xml", &generator{pkg: "disks", source: "multi.xml", path: "/"}},
+	}
+
+	for _, tt := range tests {
+		src, err := generateFile(t, tt.gen, filepath.Join("testdata", tt.xml))
+		if err != nil {
+			t.Errorf("%s: %v", tt.xml, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", strings.TrimSuffix(tt.xml, ".xml")+".golden")
+		if *update {
+			if err := ioutil.WriteFile(golden, src, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(src, want) {
+			t.Errorf("%s: output differs from %s, run go test -update to accept it", tt.xml, golden)
+		}
+
+		compile(t, tt.xml, src)
+	}
+}
+
+// compile builds the generated code as a package next to the test, so it
+// resolves godbus from the same module or GOPATH as dbus-codegen itself.
+func compile(t *testing.T, name string, src []byte) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	dir, err := ioutil.TempDir(".", "_gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "gen.go"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(goTool, "build", "-o", os.DevNull, "./"+filepath.Base(dir)).CombinedOutput()
+	if err != nil {
+		t.Errorf("%s: generated code does not build: %v\n%s", name, err, out)
+	}
+}
+
+func TestTypeNames(t *testing.T) {
+	ifaces := []introspect.Interface{
+		{Name: "com.example.vm.Disk"},
+		{Name: "com.example.host.Disk"},
+		{Name: "org.other.host.Disk"},
+		{Name: "com.example.Manager"},
+	}
+
+	names, err := (&generator{}).typeNames(ifaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"com.example.vm.Disk":   "VmDisk",
+		"com.example.host.Disk": "ExampleHostDisk",
+		"org.other.host.Disk":   "OtherHostDisk",
+		"com.example.Manager":   "Manager",
+	}
+	for iface, typ := range want {
+		if names[iface] != typ {
+			t.Errorf("type of %s = %s, want %s", iface, names[iface], typ)
+		}
+	}
+}
+
+func TestGenerateClash(t *testing.T) {
+	// Qualifying by every element still leaves the same Go name
+	_, err := (&generator{pkg: "x"}).generate([]introspect.Interface{
+		{Name: "com.example.foo_bar"},
+		{Name: "com.example.foo-bar"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "map to Go type") {
+		t.Errorf("generate error %v, want a type name clash", err)
+	}
+
+	// The signal type DiskEjected of one interface is another interface
+	_, err = (&generator{pkg: "x"}).generate([]introspect.Interface{
+		{Name: "com.example.Disk", Signals: []introspect.Signal{{Name: "Ejected"}}},
+		{Name: "com.example.DiskEjected"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "declared twice") {
+		t.Errorf("generate error %v, want a duplicate declaration", err)
+	}
+
+	src, err := generateFile(t, &generator{pkg: "x", path: "/"}, filepath.Join("testdata", "clash.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"ComExampleDisk", "OrgExampleDisk"} {
+		if !bytes.Contains(src, []byte("type "+typ+" struct")) {
+			t.Errorf("clash.xml generated no type %s", typ)
+		}
+	}
+}
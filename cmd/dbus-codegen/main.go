@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/openxt/openxt-go/pkg/argo/dbus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	output     = flag.StringP("output", "o", "", "write the generated code to file instead of stdout")
+	pkgName    = flag.StringP("package", "p", "", "package name of the generated code (required)")
+	typeName   = flag.StringP("type", "t", "", "Go type name when generating a single interface")
+	ifaceNames = flag.StringSliceP("interface", "i", nil, "generate only the named interfaces")
+	dest       = flag.StringP("dest", "d", "", "service name, required when introspecting a live service")
+	objPath    = flag.String("path", "/", "object path of the service")
+	live       = flag.BoolP("live", "l", false, "introspect the service over the bus instead of reading files")
+	address    = flag.StringP("address", "a", "", "bus address for --live (default the platform bus)")
+)
+
+func die(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "dbus-codegen: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+func readNode(file string) (*introspect.Node, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var n introspect.Node
+	if err := xml.Unmarshal(b, &n); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return &n, nil
+}
+
+func liveNode() (*introspect.Node, error) {
+	var conn *godbus.Conn
+	var err error
+
+	if *address != "" {
+		conn, err = dbus.Connect(*address)
+	} else {
+		conn, err = dbus.ConnectPlatformBus()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return introspect.Call(conn.Object(*dest, godbus.ObjectPath(*objPath)))
+}
+
+// collect gathers the interfaces of a node and its children, skipping the
+// standard interfaces every object implements.
+func collect(n *introspect.Node, ifaces map[string]introspect.Interface) {
+	for _, iface := range n.Interfaces {
+		if strings.HasPrefix(iface.Name, "org.freedesktop.DBus.") {
+			continue
+		}
+		if _, ok := ifaces[iface.Name]; !ok {
+			ifaces[iface.Name] = iface
+		}
+	}
+
+	for i := range n.Children {
+		collect(&n.Children[i], ifaces)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dbus-codegen -p package [options] file.xml...")
+		fmt.Fprintln(os.Stderr, "       dbus-codegen -p package [options] --live -d dest [--path path]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *pkgName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var nodes []*introspect.Node
+	var source string
+	switch {
+	case *live:
+		if *dest == "" {
+			die("--live requires --dest")
+		}
+		n, err := liveNode()
+		if err != nil {
+			die("introspecting %s %s: %v", *dest, *objPath, err)
+		}
+		nodes = append(nodes, n)
+		source = *dest + " " + *objPath
+	case flag.NArg() > 0:
+		for _, f := range flag.Args() {
+			n, err := readNode(f)
+			if err != nil {
+				die("%v", err)
+			}
+			nodes = append(nodes, n)
+		}
+		source = strings.Join(flag.Args(), ", ")
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	all := make(map[string]introspect.Interface)
+	for _, n := range nodes {
+		collect(n, all)
+	}
+
+	var ifaces []introspect.Interface
+	if len(*ifaceNames) > 0 {
+		for _, name := range *ifaceNames {
+			iface, ok := all[name]
+			if !ok {
+				die("interface %s not found", name)
+			}
+			ifaces = append(ifaces, iface)
+		}
+	} else {
+		for _, iface := range all {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	if len(ifaces) == 0 {
+		die("no interfaces to generate")
+	}
+
+	g := &generator{
+		pkg:    *pkgName,
+		source: source,
+		dest:   *dest,
+		path:   *objPath,
+		typ:    *typeName,
+	}
+
+	src, err := g.generate(ifaces)
+	if err != nil {
+		die("%v", err)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		die("%v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var basicTypes = map[byte]string{
+	'y': "byte",
+	'b': "bool",
+	'n': "int16",
+	'q': "uint16",
+	'i': "int32",
+	'u': "uint32",
+	'x': "int64",
+	't': "uint64",
+	'd': "float64",
+	's': "string",
+	'o': "dbus.ObjectPath",
+	'g': "dbus.Signature",
+	'h': "dbus.UnixFDIndex",
+	'v': "dbus.Variant",
+}
+
+// goType returns the Go type godbus stores a value of signature sig in.
+func goType(sig string) (string, error) {
+	t, rest, err := nextType(sig)
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		return "", fmt.Errorf("signature %q is not a single complete type", sig)
+	}
+
+	return t, nil
+}
+
+func nextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", fmt.Errorf("unexpected end of signature")
+	}
+
+	if t, ok := basicTypes[sig[0]]; ok {
+		return t, sig[1:], nil
+	}
+
+	switch sig[0] {
+	case 'a':
+		if len(sig) > 1 && sig[1] == '{' {
+			k, rest, err := nextType(sig[2:])
+			if err != nil {
+				return "", "", err
+			}
+			v, rest, err := nextType(rest)
+			if err != nil {
+				return "", "", err
+			}
+			if rest == "" || rest[0] != '}' {
+				return "", "", fmt.Errorf("unterminated dict entry in %q", sig)
+			}
+			return "map[" + k + "]" + v, rest[1:], nil
+		}
+
+		e, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "[]" + e, rest, nil
+	case '(':
+		// Structs are stored field by field into a slice
+		rest := sig[1:]
+		for rest != "" && rest[0] != ')' {
+			var err error
+			if _, rest, err = nextType(rest); err != nil {
+				return "", "", err
+			}
+		}
+		if rest == "" {
+			return "", "", fmt.Errorf("unterminated struct in %q", sig)
+		}
+		return "[]interface{}", rest[1:], nil
+	}
+
+	return "", "", fmt.Errorf("unknown type code %q in signature", sig[0])
+}
+
+// exportedName converts a D-Bus member name such as read_binary or
+// auto-s3-wake to a Go name, ReadBinary or AutoS3Wake.
+func exportedName(s string) string {
+	var b strings.Builder
+
+	upper := true
+	for _, r := range s {
+		if r == '_' || r == '-' || r == '.' {
+			upper = true
+			continue
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+
+	return name
+}
+
+var reserved = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+
+	// Names used by the generated code itself
+	"c": true, "obj": true, "err": true, "call": true, "v": true, "dbus": true,
+}
+
+// localName converts an argument name to an unexported Go identifier,
+// falling back to def for unnamed arguments.
+func localName(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	name := []rune(exportedName(s))
+	name[0] = unicode.ToLower(name[0])
+
+	if reserved[string(name)] {
+		return string(name) + "Arg"
+	}
+
+	return string(name)
+}
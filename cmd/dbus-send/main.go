@@ -196,9 +196,9 @@ func main() {
 	case *argPlatform:
 		conn, connErr = dbus.ConnectPlatformBus()
 	case *argSystem:
-		conn, connErr = godbus.ConnectSystemBus()
+		conn, connErr = dbus.ConnectSystemBus()
 	case *argSession:
-		conn, connErr = godbus.ConnectSessionBus()
+		conn, connErr = dbus.ConnectSessionBus()
 	default:
 		fmt.Println("must specify a connection type")
 		return
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+// dialBus opens a raw connection to the first reachable bus address and
+// authenticates with EXTERNAL as the proxy itself, guests are identified to
+// the rules by their domain not by the bus. The proxy relays messages
+// unchanged so it cannot use a godbus connection.
+func dialBus(address string) (net.Conn, error) {
+	c, err := dbus.DialTransport(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dbus.ClientAuth(c, []godbus.Auth{dbus.AuthExternal()}); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// readMessage decodes the next message from r, also returning its exact
+// wire bytes for forwarding.
+func readMessage(r io.Reader) (*godbus.Message, []byte, error) {
+	var raw bytes.Buffer
+
+	msg, err := godbus.DecodeMessage(io.TeeReader(r, &raw))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return msg, raw.Bytes(), nil
+}
+
+// Serials for messages the proxy sends to guests on behalf of the bus
+var serial uint32 = 1 << 31
+
+// accessDenied builds the error reply to a denied method call.
+func accessDenied(call *godbus.Message, reason string) ([]byte, error) {
+	reply := &godbus.Message{
+		Type:  godbus.TypeError,
+		Flags: godbus.FlagNoReplyExpected,
+		Headers: map[godbus.HeaderField]godbus.Variant{
+			godbus.FieldReplySerial: godbus.MakeVariant(call.Serial()),
+			godbus.FieldErrorName:   godbus.MakeVariant("org.freedesktop.DBus.Error.AccessDenied"),
+			godbus.FieldSender:      godbus.MakeVariant("org.freedesktop.DBus"),
+			godbus.FieldSignature:   godbus.MakeVariant(godbus.SignatureOf(reason)),
+		},
+		Body: []interface{}{reason},
+	}
+
+	return dbus.EncodeWithSerial(reply, atomic.AddUint32(&serial, 1))
+}
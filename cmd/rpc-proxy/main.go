@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+	"github.com/openxt/openxt-go/pkg/argo/dbus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	port       = flag.IntP("port", "p", 5555, "argo port to accept guest connections on")
+	rulesFile  = flag.StringP("rules", "r", "/etc/rpc-proxy.rules", "firewall rules file")
+	busAddress = flag.StringP("bus-address", "b", "", "address of the bus to proxy to (default $DBUS_SYSTEM_BUS_ADDRESS or the system bus)")
+	verbose    = flag.BoolP("verbose", "v", false, "log allowed calls and connection events")
+	maxConns   = flag.IntP("max-connections", "m", 16, "connections allowed from each domain")
+	handshake  = flag.DurationP("handshake-timeout", "t", 10*time.Second, "time allowed for a guest to authenticate")
+)
+
+// rules holds the current []*rule, replaced on SIGHUP
+var rules atomic.Value
+
+func reload() {
+	r, err := loadRules(*rulesFile)
+	if err != nil {
+		log.Printf("reload of %s failed, keeping current rules: %v", *rulesFile, err)
+		return
+	}
+
+	rules.Store(r)
+	log.Printf("loaded %d rules from %s", len(r), *rulesFile)
+}
+
+// guestConn serializes writes to a guest, which come from both the bus
+// relay and denial replies.
+type guestConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (g *guestConn) send(b []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, err := g.Write(b)
+	return err
+}
+
+// domains counts the open connections of each domain
+var domains = struct {
+	sync.Mutex
+	conns map[argo.DomainId]int
+}{conns: make(map[argo.DomainId]int)}
+
+// acquire takes one of the connections allowed to domid, reporting false
+// if it has none left
+func acquire(domid argo.DomainId) bool {
+	domains.Lock()
+	defer domains.Unlock()
+
+	if domains.conns[domid] >= *maxConns {
+		return false
+	}
+	domains.conns[domid]++
+
+	return true
+}
+
+func release(domid argo.DomainId) {
+	domains.Lock()
+	defer domains.Unlock()
+
+	if domains.conns[domid]--; domains.conns[domid] <= 0 {
+		delete(domains.conns, domid)
+	}
+}
+
+func remoteDomain(c net.Conn) argo.DomainId {
+	if a, ok := c.RemoteAddr().(argo.Addr); ok {
+		return a.Domain
+	}
+
+	return argo.DomainId(argo.XEN_ARGO_DOMID_ANY)
+}
+
+// busToGuest relays every message from the bus unfiltered, recording the
+// calls the guest may reply to.
+func busToGuest(guest *guestConn, pending *pendingReplies, bus net.Conn) error {
+	r := bufio.NewReader(bus)
+	for {
+		msg, raw, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		pending.delivered(msg)
+		if err := guest.send(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// guestToBus relays messages from the guest that the rules allow, answering
+// denied method calls with an AccessDenied error.
+func guestToBus(id int, domid argo.DomainId, guest *guestConn, pending *pendingReplies, bus net.Conn) error {
+	r := bufio.NewReader(guest)
+	for {
+		msg, raw, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+
+		allow, rule := decide(rules.Load().([]*rule), pending, domid, msg)
+		if allow {
+			if *verbose {
+				log.Printf("[%d] allowed %s %s", id, msg.Type, newCall(domid, msg))
+			}
+			if _, err := bus.Write(raw); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reason := "denied by default policy"
+		switch {
+		case rule != nil:
+			reason = fmt.Sprintf("denied by rule on line %d", rule.line)
+		case msg.Type == godbus.TypeMethodReply || msg.Type == godbus.TypeError:
+			reason = "denied reply to no pending call"
+		}
+		log.Printf("[%d] %s %s %s", id, reason, msg.Type, newCall(domid, msg))
+
+		if msg.Type != godbus.TypeMethodCall || msg.Flags&godbus.FlagNoReplyExpected != 0 {
+			continue
+		}
+
+		reply, err := accessDenied(msg, "rpc-proxy: "+reason)
+		if err != nil {
+			return err
+		}
+		if err := guest.send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+func handle(id int, c net.Conn) {
+	defer c.Close()
+
+	domid := remoteDomain(c)
+
+	// argo connections are in the runtime poller, so the deadline
+	// interrupts a guest that stalls the handshake
+	c.SetDeadline(time.Now().Add(*handshake))
+	if _, err := dbus.ServerAuth(c); err != nil {
+		log.Printf("[%d] domain %d: authentication failed: %v", id, domid, err)
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	bus, err := dialBus(*busAddress)
+	if err != nil {
+		log.Printf("[%d] domain %d: bus connect error: %v", id, domid, err)
+		return
+	}
+	defer bus.Close()
+
+	if *verbose {
+		log.Printf("[%d] domain %d: connected", id, domid)
+	}
+
+	guest := &guestConn{Conn: c}
+	pending := &pendingReplies{}
+	done := make(chan error, 2)
+	go func() { done <- busToGuest(guest, pending, bus) }()
+	go func() { done <- guestToBus(id, domid, guest, pending, bus) }()
+
+	// Either side going away ends the session
+	err = <-done
+	c.Close()
+	bus.Close()
+	<-done
+
+	if *verbose {
+		log.Printf("[%d] domain %d: closed: %v", id, domid, err)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rpc-proxy [-p port] [-r rules] [-b bus-address] [-m max-connections] [-t handshake-timeout] [-v]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *maxConns <= 0 || *handshake <= 0 {
+		fmt.Fprintln(os.Stderr, "max-connections and handshake-timeout must be positive")
+		os.Exit(2)
+	}
+
+	if *busAddress == "" {
+		*busAddress = os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	}
+	if *busAddress == "" {
+		*busAddress = dbus.SystemBusAddress
+	}
+
+	r, err := loadRules(*rulesFile)
+	if err != nil {
+		log.Fatalf("%s: %v", *rulesFile, err)
+	}
+	rules.Store(r)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload()
+		}
+	}()
+
+	l, err := argo.Listen(syscall.SOCK_STREAM, *port, argo.XEN_ARGO_DOMID_ANY)
+	if err != nil {
+		log.Fatalf("listen on argo port %d: %v", *port, err)
+	}
+	defer l.Close()
+
+	log.Printf("proxying argo port %d to %s", *port, *busAddress)
+
+	for id := 1; ; id++ {
+		c, err := l.Accept()
+		if err != nil {
+			log.Printf("accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		domid := remoteDomain(c)
+		if !acquire(domid) {
+			log.Printf("[%d] domain %d: rejected, %d connections open", id, domid, *maxConns)
+			c.Close()
+			continue
+		}
+
+		go func(id int, c net.Conn) {
+			defer release(domid)
+			handle(id, c)
+		}(id, c)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+// Rules file format, one rule per line with the first matching rule deciding
+// the fate of a message:
+//
+//   allow|deny [domain <id>|any] [destination <name>] [interface <name>]
+//              [member <name>] [path <path>]
+//
+// Omitted keys match anything and a trailing '*' on a name or path matches
+// by prefix. A message without an interface, which the bus dispatches to
+// the member of any interface, matches the interface of every deny rule and
+// of no allow rule. Method calls and signals sent by a guest that match no
+// rule are denied, replies and errors are only passed through in answer to
+// calls the bus delivered to the guest. The Hello call a guest must make to
+// join the bus is always allowed.
+// Blank lines and lines starting with '#' are ignored.
+
+type rule struct {
+	line        int
+	allow       bool
+	anyDomain   bool
+	domain      argo.DomainId
+	destination string
+	intf        string
+	member      string
+	path        string
+}
+
+func matchName(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(s, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == s
+}
+
+// call is the part of a message the rules look at
+type call struct {
+	domain      argo.DomainId
+	destination string
+	intf        string
+	member      string
+	path        string
+}
+
+func newCall(domid argo.DomainId, msg *godbus.Message) call {
+	c := call{domain: domid}
+
+	if v, ok := msg.Headers[godbus.FieldDestination]; ok {
+		c.destination, _ = v.Value().(string)
+	}
+	if v, ok := msg.Headers[godbus.FieldInterface]; ok {
+		c.intf, _ = v.Value().(string)
+	}
+	if v, ok := msg.Headers[godbus.FieldMember]; ok {
+		c.member, _ = v.Value().(string)
+	}
+	if v, ok := msg.Headers[godbus.FieldPath]; ok {
+		p, _ := v.Value().(godbus.ObjectPath)
+		c.path = string(p)
+	}
+
+	return c
+}
+
+func (c call) String() string {
+	return fmt.Sprintf("domain %d destination %s path %s interface %s member %s",
+		c.domain, c.destination, c.path, c.intf, c.member)
+}
+
+func (r *rule) matches(c call) bool {
+	return (r.anyDomain || r.domain == c.domain) &&
+		matchName(r.destination, c.destination) &&
+		r.matchInterface(c.intf) &&
+		matchName(r.member, c.member) &&
+		matchName(r.path, c.path)
+}
+
+// matchInterface treats a missing interface as any interface for deny rules
+// and as none for allow rules, so that leaving it out neither slips past a
+// deny nor is let in by an allow meant for one interface
+func (r *rule) matchInterface(intf string) bool {
+	if intf == "" && r.intf != "" {
+		return !r.allow
+	}
+
+	return matchName(r.intf, intf)
+}
+
+func parseRule(line string) (*rule, error) {
+	fields := strings.Fields(line)
+
+	r := &rule{anyDomain: true}
+	switch fields[0] {
+	case "allow":
+		r.allow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("expected allow or deny, got %s", fields[0])
+	}
+
+	fields = fields[1:]
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("key %s has no value", fields[len(fields)-1])
+	}
+
+	for i := 0; i < len(fields); i += 2 {
+		key, value := fields[i], fields[i+1]
+		switch key {
+		case "domain":
+			if value == "any" {
+				r.anyDomain = true
+				break
+			}
+			domid, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid domain %s", value)
+			}
+			r.anyDomain, r.domain = false, argo.DomainId(domid)
+		case "destination":
+			r.destination = value
+		case "interface":
+			r.intf = value
+		case "member":
+			r.member = value
+		case "path":
+			r.path = value
+		default:
+			return nil, fmt.Errorf("unknown key %s", key)
+		}
+	}
+
+	return r, nil
+}
+
+func parseRules(r io.Reader) ([]*rule, error) {
+	var rules []*rule
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		r.line = n
+		rules = append(rules, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func loadRules(path string) ([]*rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseRules(f)
+}
+
+// decide returns whether a message is allowed and the deciding rule, nil
+// when no rule matched or the message is a reply or always allowed. Replies
+// and errors complete a call in pending or are denied.
+func decide(rules []*rule, pending *pendingReplies, domid argo.DomainId, msg *godbus.Message) (bool, *rule) {
+	switch msg.Type {
+	case godbus.TypeMethodCall, godbus.TypeSignal:
+	case godbus.TypeMethodReply, godbus.TypeError:
+		return pending.answer(msg), nil
+	default:
+		return false, nil
+	}
+
+	c := newCall(domid, msg)
+	if c.destination == "org.freedesktop.DBus" && c.member == "Hello" &&
+		(c.intf == "" || c.intf == "org.freedesktop.DBus") {
+		return true, nil
+	}
+
+	for _, r := range rules {
+		if r.matches(c) {
+			return r.allow, r
+		}
+	}
+
+	return false, nil
+}
+
+// maxPendingReplies bounds the calls a guest may leave unanswered, calls
+// delivered past it cannot be replied to
+const maxPendingReplies = 1024
+
+// replyKey identifies a call the bus delivered to a guest, serials are only
+// unique per sender
+type replyKey struct {
+	sender string
+	serial uint32
+}
+
+// pendingReplies tracks the calls the bus delivered to a guest, the only
+// ones the guest may reply to.
+type pendingReplies struct {
+	mu    sync.Mutex
+	calls map[replyKey]struct{}
+}
+
+// delivered records msg as sent to the guest, before it is sent so that the
+// reply cannot overtake it
+func (p *pendingReplies) delivered(msg *godbus.Message) {
+	if msg.Type != godbus.TypeMethodCall || msg.Flags&godbus.FlagNoReplyExpected != 0 {
+		return
+	}
+
+	var sender string
+	if v, ok := msg.Headers[godbus.FieldSender]; ok {
+		sender, _ = v.Value().(string)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.calls == nil {
+		p.calls = make(map[replyKey]struct{})
+	}
+	if len(p.calls) < maxPendingReplies {
+		p.calls[replyKey{sender, msg.Serial()}] = struct{}{}
+	}
+}
+
+// answer reports whether msg replies to a pending call, completing it
+func (p *pendingReplies) answer(msg *godbus.Message) bool {
+	var key replyKey
+	if v, ok := msg.Headers[godbus.FieldDestination]; ok {
+		key.sender, _ = v.Value().(string)
+	}
+	v, ok := msg.Headers[godbus.FieldReplySerial]
+	if !ok {
+		return false
+	}
+	if key.serial, ok = v.Value().(uint32); !ok {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.calls[key]; !ok {
+		return false
+	}
+	delete(p.calls, key)
+
+	return true
+}
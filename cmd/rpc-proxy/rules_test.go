@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+	"github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		line string
+		want *rule
+		err  bool
+	}{
+		{line: "allow", want: &rule{allow: true, anyDomain: true}},
+		{line: "deny domain any", want: &rule{anyDomain: true}},
+		{
+			line: "allow domain 7 destination com.citrix.* interface com.citrix.xenclient.xenmgr member list_vms path /",
+			want: &rule{allow: true, domain: 7, destination: "com.citrix.*", intf: "com.citrix.xenclient.xenmgr", member: "list_vms", path: "/"},
+		},
+		{line: "permit", err: true},
+		{line: "allow domain", err: true},
+		{line: "allow domain 65536", err: true},
+		{line: "allow domain -1", err: true},
+		{line: "allow sender foo", err: true},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRule(tt.line)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseRule(%q) succeeded", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRule(%q) error: %v", tt.line, err)
+			continue
+		}
+		if *r != *tt.want {
+			t.Errorf("parseRule(%q) = %+v, want %+v", tt.line, *r, *tt.want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules(strings.NewReader("# comment\n\nallow member Ping\n  deny\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].line != 3 || rules[1].line != 4 {
+		t.Errorf("parseRules returned %d rules on the wrong lines", len(rules))
+	}
+
+	if _, err := parseRules(strings.NewReader("allow\nbogus\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("parseRules error %v, want one on line 2", err)
+	}
+}
+
+func message(typ godbus.Type, dest, path, intf, member string) *godbus.Message {
+	msg := &godbus.Message{Type: typ, Headers: make(map[godbus.HeaderField]godbus.Variant)}
+
+	for f, v := range map[godbus.HeaderField]string{
+		godbus.FieldDestination: dest,
+		godbus.FieldInterface:   intf,
+		godbus.FieldMember:      member,
+	} {
+		if v != "" {
+			msg.Headers[f] = godbus.MakeVariant(v)
+		}
+	}
+	if path != "" {
+		msg.Headers[godbus.FieldPath] = godbus.MakeVariant(godbus.ObjectPath(path))
+	}
+
+	return msg
+}
+
+func TestDecide(t *testing.T) {
+	rules, err := parseRules(strings.NewReader(`
+deny interface org.freedesktop.DBus.Properties member Set
+deny domain 5
+allow domain 3 destination com.citrix.xenclient.xenmgr interface com.citrix.xenclient.xenmgr member list_*
+allow destination com.citrix.xenclient.xenmgr member Get
+allow destination org.freedesktop.DBus.* path /
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		xenmgr = "com.citrix.xenclient.xenmgr"
+		props  = "org.freedesktop.DBus.Properties"
+	)
+	call := godbus.TypeMethodCall
+
+	tests := []struct {
+		name  string
+		domid argo.DomainId
+		msg   *godbus.Message
+		allow bool
+		line  int
+	}{
+		{"prefix member", 3, message(call, xenmgr, "/", xenmgr, "list_vms"), true, 4},
+		{"other domain", 4, message(call, xenmgr, "/", xenmgr, "list_vms"), false, 0},
+		{"first match wins", 3, message(call, xenmgr, "/", props, "Set"), false, 2},
+		{"denied domain", 5, message(call, "org.freedesktop.DBus", "/", "org.freedesktop.DBus", "ListNames"), false, 3},
+		{"prefix destination", 4, message(call, "org.freedesktop.DBus.Local", "/", "", "Ping"), true, 6},
+		{"default deny", 4, message(call, "org.example", "/", "org.example", "Frob"), false, 0},
+		{"default deny signal", 4, message(godbus.TypeSignal, "", "/", "org.example", "Changed"), false, 0},
+		{"missing interface matches deny", 4, message(call, xenmgr, "/", "", "Set"), false, 2},
+		{"missing interface skips allow", 3, message(call, xenmgr, "/", "", "list_vms"), false, 0},
+		{"missing interface with open allow", 4, message(call, xenmgr, "/", "", "Get"), true, 5},
+		{"hello", 5, message(call, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello"), true, 0},
+		{"hello without interface", 5, message(call, "org.freedesktop.DBus", "/org/freedesktop/DBus", "", "Hello"), true, 0},
+		{"hello to another interface", 5, message(call, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.example", "Hello"), false, 3},
+	}
+
+	for _, tt := range tests {
+		allow, r := decide(rules, &pendingReplies{}, tt.domid, tt.msg)
+		line := 0
+		if r != nil {
+			line = r.line
+		}
+		if allow != tt.allow || line != tt.line {
+			t.Errorf("%s: decide = %v on line %d, want %v on line %d", tt.name, allow, line, tt.allow, tt.line)
+		}
+	}
+}
+
+func TestDecideReplies(t *testing.T) {
+	pending := &pendingReplies{}
+
+	reply := func(typ godbus.Type, dest string, serial uint32) *godbus.Message {
+		msg := message(typ, dest, "", "", "")
+		msg.Headers[godbus.FieldReplySerial] = godbus.MakeVariant(serial)
+		return msg
+	}
+
+	// A call to the guest numbered 7 by its sender, as the bus delivers it
+	call := &godbus.Message{
+		Type: godbus.TypeMethodCall,
+		Headers: map[godbus.HeaderField]godbus.Variant{
+			godbus.FieldSender: godbus.MakeVariant(":1.5"),
+			godbus.FieldPath:   godbus.MakeVariant(godbus.ObjectPath("/")),
+			godbus.FieldMember: godbus.MakeVariant("Ping"),
+		},
+	}
+	pending.delivered(encode(t, call, 7))
+
+	if allow, _ := decide(nil, pending, 3, reply(godbus.TypeMethodReply, ":1.5", 8)); allow {
+		t.Errorf("reply to a serial the guest was never sent allowed")
+	}
+	if allow, _ := decide(nil, pending, 3, reply(godbus.TypeError, ":1.6", 7)); allow {
+		t.Errorf("reply to another sender allowed")
+	}
+	if allow, _ := decide(nil, pending, 3, reply(godbus.TypeMethodReply, ":1.5", 7)); !allow {
+		t.Errorf("reply to a delivered call denied")
+	}
+	if allow, _ := decide(nil, pending, 3, reply(godbus.TypeMethodReply, ":1.5", 7)); allow {
+		t.Errorf("second reply to the same call allowed")
+	}
+
+	call.Flags = godbus.FlagNoReplyExpected
+	pending.delivered(encode(t, call, 9))
+	if allow, _ := decide(nil, pending, 3, reply(godbus.TypeMethodReply, ":1.5", 9)); allow {
+		t.Errorf("reply to a call expecting none allowed")
+	}
+}
+
+// encode gives msg a serial the way it arrives from the bus
+func encode(t *testing.T, msg *godbus.Message, serial uint32) *godbus.Message {
+	b, err := dbus.EncodeWithSerial(msg, serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := godbus.DecodeMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+func die(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+	fmt.Fprintln(os.Stderr)
+	os.Exit(1)
+}
+
+func usage() {
+	die(
+		`Usage: viptables <command> [<args>]
+
+Available commands are:
+  list					List the firewall rules with their positions
+  add [-I <pos>] <rule>			Append <rule>, or insert it at <pos>
+  delete <pos> | <rule>			Delete the rule at <pos> or matching <rule>
+  flush					Delete all rules
+  save [<file>]				Write the rules to <file> or stdout
+  restore [<file>]			Replace the rules with those in <file> or stdin
+  help					Print this help
+
+Rules take the form:
+  [-s <domain>[:<port>]] [-d <domain>[:<port>]] -j ACCEPT|REJECT
+
+where <domain> and <port> may be "any". Positions start at 1.`)
+}
+
+func parsePosition(s string) int {
+	pos, err := strconv.Atoi(s)
+	if err != nil || pos < 1 {
+		die("invalid rule position %s", s)
+	}
+
+	return pos - 1
+}
+
+func parseRule(args []string) argo.VIpTablesRule {
+	rule, err := argo.ParseVIpTablesRule(strings.Join(args, " "))
+	if err != nil {
+		die("invalid rule: %v", err)
+	}
+
+	return rule
+}
+
+func vipt_list(args []string) {
+	if len(args) != 0 {
+		usage()
+	}
+
+	rules, err := argo.ViptablesList()
+	if err != nil {
+		die("list error: %v", err)
+	}
+
+	for i, r := range rules {
+		fmt.Printf("%3d %s\n", i+1, r)
+	}
+}
+
+func vipt_add(args []string) {
+	pos := argo.VIpTablesEnd
+
+	if len(args) >= 2 && (args[0] == "-I" || args[0] == "--insert") {
+		pos = parsePosition(args[1])
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	if err := argo.ViptablesAdd(parseRule(args), pos); err != nil {
+		die("add error: %v", err)
+	}
+}
+
+func vipt_delete(args []string) {
+	var err error
+
+	switch {
+	case len(args) == 0:
+		usage()
+	case len(args) == 1:
+		err = argo.ViptablesDel(parsePosition(args[0]))
+	default:
+		err = argo.ViptablesDelRule(parseRule(args))
+	}
+
+	if err != nil {
+		die("delete error: %v", err)
+	}
+}
+
+func vipt_flush(args []string) {
+	if len(args) != 0 {
+		usage()
+	}
+
+	if err := argo.ViptablesFlush(); err != nil {
+		die("flush error: %v", err)
+	}
+}
+
+// The save format is one "-A <rule>" line per rule in evaluation order,
+// with '#' comment lines, mirroring iptables-save.
+func save(w io.Writer, rules []argo.VIpTablesRule) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# Generated by viptables save on %s\n", time.Now().Format(time.RFC1123))
+	for _, r := range rules {
+		fmt.Fprintf(bw, "-A %s\n", r)
+	}
+
+	return bw.Flush()
+}
+
+func load(r io.Reader) ([]argo.VIpTablesRule, error) {
+	var rules []argo.VIpTablesRule
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "-A ") {
+			return nil, fmt.Errorf("line %d: expected -A <rule>", n)
+		}
+
+		rule, err := argo.ParseVIpTablesRule(line[3:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, s.Err()
+}
+
+func vipt_save(args []string) {
+	if len(args) > 1 {
+		usage()
+	}
+
+	rules, err := argo.ViptablesList()
+	if err != nil {
+		die("list error: %v", err)
+	}
+
+	w := os.Stdout
+	if len(args) == 1 {
+		w, err = os.Create(args[0])
+		if err != nil {
+			die("save error: %v", err)
+		}
+		defer w.Close()
+	}
+
+	if err := save(w, rules); err != nil {
+		die("save error: %v", err)
+	}
+}
+
+func vipt_restore(args []string) {
+	if len(args) > 1 {
+		usage()
+	}
+
+	r := os.Stdin
+	if len(args) == 1 {
+		var err error
+		r, err = os.Open(args[0])
+		if err != nil {
+			die("restore error: %v", err)
+		}
+		defer r.Close()
+	}
+
+	// Parse everything before touching the running rules so a bad file
+	// does not leave the firewall half configured
+	rules, err := load(r)
+	if err != nil {
+		die("restore error: %v", err)
+	}
+
+	prev, err := argo.ViptablesList()
+	if err != nil {
+		die("list error: %v", err)
+	}
+
+	if err := replace(rules); err != nil {
+		fmt.Fprintf(os.Stderr, "restore error: %v\n", err)
+
+		// Put back the rules that were running rather than leave the
+		// firewall with part of the new set
+		if err := replace(prev); err != nil {
+			die("rollback error: %v", err)
+		}
+		die("previous rules restored")
+	}
+}
+
+// replace flushes the firewall and appends rules in order. On failure the
+// error says how many of the rules were applied.
+func replace(rules []argo.VIpTablesRule) error {
+	if err := argo.ViptablesFlush(); err != nil {
+		return fmt.Errorf("flush: %v", err)
+	}
+
+	for i, rule := range rules {
+		if err := argo.ViptablesAdd(rule, argo.VIpTablesEnd); err != nil {
+			return fmt.Errorf("add of rule %d (%s): %v, %d of %d rules applied", i+1, rule, err, i, len(rules))
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	operation, args := os.Args[1], os.Args[2:]
+
+	switch operation {
+	case "list":
+		vipt_list(args)
+	case "add":
+		vipt_add(args)
+	case "delete":
+		vipt_delete(args)
+	case "flush":
+		vipt_flush(args)
+	case "save":
+		vipt_save(args)
+	case "restore":
+		vipt_restore(args)
+	default:
+		usage()
+	}
+}
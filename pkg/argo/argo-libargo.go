@@ -9,6 +9,7 @@ package argo
 import "C"
 import (
 	"unsafe"
+	"net"
 	"os"
 	"syscall"
 	"fmt"
@@ -106,6 +107,15 @@ func Listen(c *Conn, partner DomainId) (ln *Listener, err error) {
 	return nil, errors.New("unsupported function: listen")
 }
 
-func (l *Listener) Accept() (*Conn, error) {
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.AcceptArgo()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (l *Listener) AcceptArgo() (*Conn, error) {
 	return nil, errors.New("unsupported function: accept")
 }
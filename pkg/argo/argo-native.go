@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"net"
 	"os"
 	"syscall"
 	"unsafe"
@@ -74,6 +75,25 @@ func (a *Addr) toC(w io.Writer) error {
 	return nil
 }
 
+func ringIdFromC(r io.Reader, id *RingId) error {
+	err := binary.Read(r, binary.LittleEndian, &id.Domain)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Read(r, binary.LittleEndian, &id.Partner)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Read(r, binary.LittleEndian, &id.Port)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (r *RingId) toC(w io.Writer) error {
 	err := binary.Write(w, binary.LittleEndian, r.Domain)
 	if err != nil {
@@ -93,20 +113,96 @@ func (r *RingId) toC(w io.Writer) error {
 	return nil
 }
 
+// ioctl issues req on the descriptor of file. It goes through the raw
+// descriptor rather than File.Fd, which would switch the file to blocking
+// mode and take it out of the runtime poller, disabling deadlines.
+func ioctl(file *os.File, req uintptr, arg unsafe.Pointer) error {
+	return ioctlValue(file, req, uintptr(arg))
+}
+
+func ioctlValue(file *os.File, req, arg uintptr) error {
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// ioctlRead issues req, waiting for file to become readable for as long as
+// the driver returns EAGAIN. The wait honours the read deadline of file.
+func ioctlRead(file *os.File, req uintptr, arg unsafe.Pointer) (uintptr, error) {
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var r uintptr
+	var errno syscall.Errno
+	err = rc.Read(func(fd uintptr) bool {
+		r, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+		return errno != syscall.EAGAIN
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return r, nil
+}
+
+// waitWrite blocks until file is writable, honouring its write deadline.
+func waitWrite(file *os.File) error {
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	waited := false
+	return rc.Write(func(fd uintptr) bool {
+		if waited {
+			return true
+		}
+		waited = true
+		return false
+	})
+}
+
 func connect(file *os.File, addr Addr) error {
 	var buf bytes.Buffer
 
 	addr.toC(&buf)
 
-	_, _, errno := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		file.Fd(),
-		uintptr(argoIocConnect),
-		uintptr(unsafe.Pointer(&buf.Bytes()[0])),
-	)
+	err := ioctl(file, argoIocConnect, unsafe.Pointer(&buf.Bytes()[0]))
+	if err != syscall.EINPROGRESS {
+		return err
+	}
 
-	if errno != 0 {
-		return errors.New(errno.Error())
+	// The descriptor is non-blocking so, as with a socket, the connect
+	// completes asynchronously and its result is collected once writable
+	if err := waitWrite(file); err != nil {
+		return err
+	}
+
+	v, err := getIntIoctl(file, argoIocGetConnectErr)
+	if err != nil {
+		return err
+	}
+	if v != 0 {
+		return syscall.Errno(v)
 	}
 
 	return nil
@@ -117,52 +213,68 @@ func bind(file *os.File, id RingId) error {
 
 	id.toC(&buf)
 
-	_, _, errno := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		file.Fd(),
-		uintptr(argoIocBind),
-		uintptr(unsafe.Pointer(&buf.Bytes()[0])),
-	)
+	return ioctl(file, argoIocBind, unsafe.Pointer(&buf.Bytes()[0]))
+}
 
-	if errno != 0 {
-		return errors.New(errno.Error())
+func getSockName(file *os.File) (RingId, error) {
+	var id RingId
+	b := make([]byte, argoRingIdSize)
+
+	if err := ioctl(file, argoIocGetSockName, unsafe.Pointer(&b[0])); err != nil {
+		return id, err
 	}
 
-	return nil
+	err := ringIdFromC(bytes.NewBuffer(b), &id)
+
+	return id, err
 }
 
-func listen(file *os.File, backlog int) error {
-	_, _, errno := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		file.Fd(),
-		uintptr(argoIocListen),
-		uintptr(backlog),
-	)
+func getPeerName(file *os.File) (Addr, error) {
+	var a Addr
+	b := make([]byte, xenArgoAddrSize)
 
-	if errno != 0 {
-		return errors.New(errno.Error())
+	if err := ioctl(file, argoIocGetPeerName, unsafe.Pointer(&b[0])); err != nil {
+		return a, err
 	}
 
-	return nil
+	err := addrFromC(bytes.NewBuffer(b), &a)
+
+	return a, err
+}
+
+func getIntIoctl(file *os.File, req uintptr) (int, error) {
+	var v int32
+
+	if err := ioctl(file, req, unsafe.Pointer(&v)); err != nil {
+		return 0, err
+	}
+
+	return int(v), nil
+}
+
+func listen(file *os.File, backlog int) error {
+	return ioctlValue(file, argoIocListen, uintptr(backlog))
 }
 
 func accept(file *os.File) (*Conn, error) {
 	b := make([]byte, xenArgoAddrSize)
 
-	nfd, _, errno := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		file.Fd(),
-		uintptr(argoIocAccept),
-		uintptr(unsafe.Pointer(&b[0])),
-	)
-	if errno != 0 {
-		return nil, errors.New(errno.Error())
+	nfd, err := ioctlRead(file, argoIocAccept, unsafe.Pointer(&b[0]))
+	if err != nil {
+		return nil, err
 	}
 
 	buf := bytes.NewBuffer(b)
 	c := &Conn{}
 
 	if err := addrFromC(buf, &c.addr); err != nil {
+		syscall.Close(int(nfd))
+		return nil, err
+	}
+
+	// Non-blocking descriptors are added to the runtime poller by NewFile
+	if err := syscall.SetNonblock(int(nfd), true); err != nil {
+		syscall.Close(int(nfd))
 		return nil, err
 	}
 	c.file = os.NewFile(nfd, file.Name())
@@ -198,20 +310,38 @@ func open(sockType, domid, port int) (*Conn, error) {
 		return nil, errors.New("unsupported socket type")
 	}
 
-	if err := syscall.SetNonblock(int(c.file.Fd()), false); err != nil {
-		return nil, err
-	}
-
 	return c, nil
 }
 
+func setRingSize(file *os.File, size uint32) error {
+	return ioctl(file, argoIocSetRingSize, unsafe.Pointer(&size))
+}
+
 func Dial(sockType, domid, port int) (*Conn, error) {
+	var d Dialer
+
+	return d.Dial(sockType, domid, port)
+}
+
+// Dial connects to port on domid with the options of d, a nil d being the
+// zero Dialer.
+func (d *Dialer) Dial(sockType, domid, port int) (*Conn, error) {
+	if d == nil {
+		d = &Dialer{}
+	}
 
 	c, err := open(sockType, domid, port)
 	if err != nil {
 		return nil, err
 	}
 
+	if d.RingSize != 0 {
+		if err := setRingSize(c.file, d.RingSize); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
 	if err := connect(c.file, c.addr); err != nil {
 		c.Close()
 		return nil, err
@@ -221,6 +351,12 @@ func Dial(sockType, domid, port int) (*Conn, error) {
 }
 
 func Listen(sockType, port int, partner DomainId) (ln *Listener, err error) {
+	var lc ListenConfig
+
+	return lc.Listen(sockType, port, partner)
+}
+
+func (lc *ListenConfig) Listen(sockType, port int, partner DomainId) (*Listener, error) {
 
 	c, err := open(sockType, XEN_ARGO_DOMID_ANY, port)
 	if err != nil {
@@ -236,26 +372,107 @@ func Listen(sockType, port int, partner DomainId) (ln *Listener, err error) {
 		},
 	}
 
+	if lc.RingSize != 0 {
+		if err := setRingSize(l.conn.file, lc.RingSize); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
 	if err := bind(l.conn.file, l.ring); err != nil {
+		c.Close()
 		return nil, err
 	}
 
-	if err := listen(l.conn.file, 5); err != nil {
+	backlog := lc.Backlog
+	if backlog == 0 {
+		backlog = 5
+	}
+
+	if err := listen(l.conn.file, backlog); err != nil {
+		c.Close()
 		return nil, err
 	}
 
 	return l, nil
 }
 
-func (l *Listener) Accept() (*Conn, error) {
-	c, err := accept(l.conn.file)
+// ListenDatagram binds a datagram socket to port for receiving messages
+// from partner, which may be XEN_ARGO_DOMID_ANY.
+func ListenDatagram(port int, partner DomainId) (*Conn, error) {
+	c, err := open(syscall.SOCK_DGRAM, XEN_ARGO_DOMID_ANY, port)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = syscall.SetNonblock(int(l.conn.file.Fd()), false); err != nil {
+	ring := RingId{
+		Domain:  XEN_ARGO_DOMID_ANY,
+		Partner: partner,
+		Port:    c.addr.Port,
+	}
+
+	if err := bind(c.file, ring); err != nil {
+		c.Close()
 		return nil, err
 	}
 
+	c.addr = Addr{Port: XEN_ARGO_PORT_ANY, Domain: partner}
+
 	return c, nil
 }
+
+// Accept waits for and returns the next connection to the listener. It
+// implements the net.Listener interface, see AcceptArgo for the concrete type.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.AcceptArgo()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// AcceptArgo waits for and returns the next connection to the listener.
+// Closing the listener unblocks it.
+func (l *Listener) AcceptArgo() (*Conn, error) {
+	return accept(l.conn.file)
+}
+
+// LocalAddr returns the local address of the ring the connection is bound to.
+func (c *Conn) LocalAddr() net.Addr {
+	id, err := getSockName(c.file)
+	if err != nil {
+		return Addr{Port: XEN_ARGO_PORT_ANY, Domain: XEN_ARGO_DOMID_ANY}
+	}
+
+	return Addr{Port: id.Port, Domain: id.Domain}
+}
+
+// SockType returns the socket type, syscall.SOCK_STREAM or
+// syscall.SOCK_DGRAM, as reported by the driver.
+func (c *Conn) SockType() (int, error) {
+	return getIntIoctl(c.file, argoIocGetSockType)
+}
+
+// SockName returns the ring the connection is bound to.
+func (c *Conn) SockName() (RingId, error) {
+	return getSockName(c.file)
+}
+
+// PeerName returns the peer address as reported by the driver.
+func (c *Conn) PeerName() (Addr, error) {
+	return getPeerName(c.file)
+}
+
+// ConnectError returns the pending error, if any, of a connect.
+func (c *Conn) ConnectError() error {
+	v, err := getIntIoctl(c.file, argoIocGetConnectErr)
+	if err != nil {
+		return err
+	}
+	if v != 0 {
+		return syscall.Errno(v)
+	}
+
+	return nil
+}
@@ -3,13 +3,25 @@ package argo
 // Common functions for Conn struct
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"time"
+)
+
+var (
+	_ net.Conn     = (*Conn)(nil)
+	_ net.Listener = (*Listener)(nil)
+	_ net.Addr     = Addr{}
 )
 
 func (c *Conn) File() *os.File {
 	return c.file
 }
 
+// Fd returns the descriptor of the connection. As with os.File.Fd, this
+// puts it in blocking mode, after which deadlines are not supported and
+// Close no longer unblocks a pending Read or Write.
 func (c *Conn) Fd() uintptr {
 	return c.file.Fd()
 }
@@ -26,3 +38,43 @@ func (c *Conn) Close() error {
 	return c.file.Close()
 }
 
+// RemoteAddr returns the address of the peer: the destination of a dialed
+// connection or the source of an accepted one.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline sets the read and write deadlines. Deadlines rely on the
+// descriptor being in the runtime poller, which is the case unless Fd has
+// been called, otherwise they fail with os.ErrNoDeadline.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.file.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.file.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.file.SetWriteDeadline(t)
+}
+
+// Common functions for Listener struct
+
+func (l *Listener) Close() error {
+	return l.conn.Close()
+}
+
+func (l *Listener) Addr() net.Addr {
+	return Addr{Port: l.ring.Port, Domain: l.ring.Domain}
+}
+
+// Common functions for Addr struct
+
+func (a Addr) Network() string {
+	return "argo"
+}
+
+func (a Addr) String() string {
+	return fmt.Sprintf("%d:%d", a.Domain, a.Port)
+}
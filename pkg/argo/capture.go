@@ -0,0 +1,164 @@
+package argo
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Capture event types carried in the argo capture header
+const (
+	CaptureOpen  = 0
+	CaptureClose = 1
+	CaptureData  = 2
+)
+
+/*
+Each captured record starts with a 16 byte header in network byte order,
+followed by the payload for CaptureData records:
+
+struct argo_capture_hdr {
+    uint8_t event;		1
+    uint8_t pad;		1
+    domid_t src_domain;		2
+    xen_argo_port_t src_port;	4
+    domid_t dst_domain;		2
+    uint16_t pad;		2
+    xen_argo_port_t dst_port;	4
+};
+*/
+const captureHdrSize = 16
+
+// Capture records connection open and close events and payload chunks of
+// wrapped connections to a pcapng stream. It is safe for concurrent use by
+// multiple connections.
+type Capture struct {
+	mu  sync.Mutex
+	w   *pcapngWriter
+	err error
+}
+
+// NewCapture writes the pcapng file header to w and returns a Capture ready
+// to wrap connections and listeners.
+func NewCapture(w io.Writer) (*Capture, error) {
+	p, err := newPcapngWriter(w, "argo")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Capture{w: p}, nil
+}
+
+// Err returns the first error encountered writing records, after which no
+// further records are written.
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *Capture) record(event uint8, src, dst Addr, payload []byte, flags uint32) {
+	b := make([]byte, captureHdrSize+len(payload))
+	b[0] = event
+	binary.BigEndian.PutUint16(b[2:], uint16(src.Domain))
+	binary.BigEndian.PutUint32(b[4:], src.Port)
+	binary.BigEndian.PutUint16(b[8:], uint16(dst.Domain))
+	binary.BigEndian.PutUint32(b[12:], dst.Port)
+	copy(b[captureHdrSize:], payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = c.w.packet(time.Now(), b, flags)
+	}
+}
+
+// toAddr converts the address of any transport to an argo address, those
+// of other transports are recorded as the wildcard address.
+func toAddr(a net.Addr) Addr {
+	switch v := a.(type) {
+	case Addr:
+		return v
+	case *Addr:
+		return *v
+	default:
+		return Addr{Port: XEN_ARGO_PORT_ANY, Domain: XEN_ARGO_DOMID_ANY}
+	}
+}
+
+// Conn wraps conn, an outgoing connection, so that its traffic is recorded.
+// The open event is recorded immediately.
+func (c *Capture) Conn(conn net.Conn) net.Conn {
+	return c.wrap(conn, false)
+}
+
+func (c *Capture) wrap(conn net.Conn, accepted bool) net.Conn {
+	cc := &captureConn{
+		Conn:   conn,
+		c:      c,
+		local:  toAddr(conn.LocalAddr()),
+		remote: toAddr(conn.RemoteAddr()),
+	}
+
+	if accepted {
+		c.record(CaptureOpen, cc.remote, cc.local, nil, pcapngEpbFlagInbound)
+	} else {
+		c.record(CaptureOpen, cc.local, cc.remote, nil, pcapngEpbFlagOutbound)
+	}
+
+	return cc
+}
+
+// Listener wraps l so that every accepted connection is recorded.
+func (c *Capture) Listener(l net.Listener) net.Listener {
+	return &captureListener{Listener: l, c: c}
+}
+
+type captureConn struct {
+	net.Conn
+	c         *Capture
+	local     Addr
+	remote    Addr
+	closeOnce sync.Once
+}
+
+func (cc *captureConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	if n > 0 {
+		cc.c.record(CaptureData, cc.remote, cc.local, p[:n], pcapngEpbFlagInbound)
+	}
+	return n, err
+}
+
+func (cc *captureConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	if n > 0 {
+		cc.c.record(CaptureData, cc.local, cc.remote, p[:n], pcapngEpbFlagOutbound)
+	}
+	return n, err
+}
+
+func (cc *captureConn) Close() error {
+	cc.closeOnce.Do(func() {
+		cc.c.record(CaptureClose, cc.local, cc.remote, nil, 0)
+	})
+	return cc.Conn.Close()
+}
+
+type captureListener struct {
+	net.Listener
+	c *Capture
+}
+
+func (cl *captureListener) Accept() (net.Conn, error) {
+	conn, err := cl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return cl.c.wrap(conn, true), nil
+}
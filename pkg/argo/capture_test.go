@@ -0,0 +1,106 @@
+package argo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// readBlocks splits a little endian pcapng stream into block types and bodies
+func readBlocks(t *testing.T, b []byte) ([]uint32, [][]byte) {
+	var types []uint32
+	var bodies [][]byte
+
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block header")
+		}
+		typ := binary.LittleEndian.Uint32(b[0:])
+		total := binary.LittleEndian.Uint32(b[4:])
+		if total%4 != 0 || int(total) > len(b) {
+			t.Fatalf("invalid block length %d", total)
+		}
+		if binary.LittleEndian.Uint32(b[total-4:]) != total {
+			t.Fatalf("trailing block length mismatch")
+		}
+		types = append(types, typ)
+		bodies = append(bodies, b[8:total-4])
+		b = b[total:]
+	}
+
+	return types, bodies
+}
+
+func TestCapture(t *testing.T) {
+	var out bytes.Buffer
+
+	capture, err := NewCapture(&out)
+	if err != nil {
+		t.Fatalf("NewCapture error: %v", err)
+	}
+
+	client, server := net.Pipe()
+	c := capture.Conn(client)
+
+	go func() {
+		b := make([]byte, 5)
+		io.ReadFull(server, b)
+		server.Write([]byte("world!"))
+		server.Close()
+	}()
+
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	b := make([]byte, 6)
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	c.Close()
+
+	if err := capture.Err(); err != nil {
+		t.Fatalf("capture error: %v", err)
+	}
+
+	types, bodies := readBlocks(t, out.Bytes())
+	want := []uint32{pcapngSectionHeader, pcapngInterfaceDesc,
+		pcapngEnhancedPacket, pcapngEnhancedPacket, pcapngEnhancedPacket, pcapngEnhancedPacket}
+	if len(types) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(types), len(want))
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("block %d type %#x, want %#x", i, types[i], want[i])
+		}
+	}
+
+	if binary.LittleEndian.Uint32(bodies[0]) != pcapngByteOrderMagic {
+		t.Errorf("invalid byte order magic")
+	}
+	if binary.LittleEndian.Uint16(bodies[1]) != pcapngLinkTypeArgo {
+		t.Errorf("invalid link type")
+	}
+
+	events := []struct {
+		event   uint8
+		payload string
+	}{
+		{CaptureOpen, ""},
+		{CaptureData, "hello"},
+		{CaptureData, "world!"},
+		{CaptureClose, ""},
+	}
+	for i, e := range events {
+		epb := bodies[i+2]
+		caplen := binary.LittleEndian.Uint32(epb[12:])
+		data := epb[20 : 20+caplen]
+		if data[0] != e.event {
+			t.Errorf("record %d event %d, want %d", i, data[0], e.event)
+		}
+		if string(data[captureHdrSize:]) != e.payload {
+			t.Errorf("record %d payload %q, want %q", i, data[captureHdrSize:], e.payload)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package argo
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrChaosReset  = errors.New("argo: connection reset by fault injection")
+	ErrChaosAccept = errors.New("argo: accept failed by fault injection")
+)
+
+// ChaosConfig describes the faults injected by a Chaos wrapper. Rates are
+// probabilities between 0 and 1 evaluated on each operation.
+type ChaosConfig struct {
+	// Seed for the fault sequence, the same seed and sequence of
+	// operations give the same faults
+	Seed int64
+
+	// Latency is added before every read and write, plus a random
+	// amount up to Jitter
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Bandwidth caps each direction of a connection in bytes per
+	// second, zero is unlimited
+	Bandwidth int
+
+	// ShortIORate is the rate at which reads and writes transfer only
+	// part of the buffer, short writes return io.ErrShortWrite
+	ShortIORate float64
+
+	// ResetRate is the rate at which a read or write closes the
+	// connection and fails with ErrChaosReset
+	ResetRate float64
+
+	// AcceptFailRate is the rate at which Accept closes the incoming
+	// connection and fails with ErrChaosAccept
+	AcceptFailRate float64
+}
+
+// Chaos injects faults into wrapped connections and listeners, working with
+// any transport including *Conn, *Listener and PipeListener.
+type Chaos struct {
+	cfg ChaosConfig
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func NewChaos(cfg ChaosConfig) *Chaos {
+	return &Chaos{
+		cfg: cfg,
+		rnd: rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+func (ch *Chaos) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return ch.rnd.Float64() < rate
+}
+
+// shorten picks a length in [1, n) for a short transfer of n bytes
+func (ch *Chaos) shorten(n int) int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return 1 + ch.rnd.Intn(n-1)
+}
+
+func (ch *Chaos) delay(n int) {
+	d := ch.cfg.Latency
+	if ch.cfg.Jitter > 0 {
+		ch.mu.Lock()
+		d += time.Duration(ch.rnd.Int63n(int64(ch.cfg.Jitter)))
+		ch.mu.Unlock()
+	}
+	if ch.cfg.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(ch.cfg.Bandwidth)
+	}
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (ch *Chaos) Conn(conn net.Conn) net.Conn {
+	return &chaosConn{Conn: conn, ch: ch}
+}
+
+func (ch *Chaos) Listener(l net.Listener) net.Listener {
+	return &chaosListener{Listener: l, ch: ch}
+}
+
+type chaosConn struct {
+	net.Conn
+	ch *Chaos
+}
+
+func (c *chaosConn) Read(p []byte) (int, error) {
+	if c.ch.chance(c.ch.cfg.ResetRate) {
+		c.Conn.Close()
+		return 0, ErrChaosReset
+	}
+
+	if len(p) > 1 && c.ch.chance(c.ch.cfg.ShortIORate) {
+		p = p[:c.ch.shorten(len(p))]
+	}
+
+	n, err := c.Conn.Read(p)
+	c.ch.delay(n)
+
+	return n, err
+}
+
+func (c *chaosConn) Write(p []byte) (int, error) {
+	if c.ch.chance(c.ch.cfg.ResetRate) {
+		c.Conn.Close()
+		return 0, ErrChaosReset
+	}
+
+	short := len(p) > 1 && c.ch.chance(c.ch.cfg.ShortIORate)
+	if short {
+		p = p[:c.ch.shorten(len(p))]
+	}
+
+	c.ch.delay(len(p))
+	n, err := c.Conn.Write(p)
+	if err == nil && short {
+		err = io.ErrShortWrite
+	}
+
+	return n, err
+}
+
+type chaosListener struct {
+	net.Listener
+	ch *Chaos
+}
+
+func (l *chaosListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.ch.chance(l.ch.cfg.AcceptFailRate) {
+		c.Close()
+		return nil, ErrChaosAccept
+	}
+
+	return l.ch.Conn(c), nil
+}
@@ -0,0 +1,91 @@
+package argo
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// shortReads returns the sizes of the reads needed to receive 64 bytes
+// through a connection wrapped with the given seed.
+func shortReads(t *testing.T, seed int64) []int {
+	var sizes []int
+
+	ch := NewChaos(ChaosConfig{Seed: seed, ShortIORate: 0.5})
+	a, b := Pipe(Addr{Domain: 1, Port: 1}, Addr{Domain: 0, Port: 2})
+	c := ch.Conn(a)
+
+	go func() {
+		b.Write(bytes.Repeat([]byte{'x'}, 64))
+		b.Close()
+	}()
+
+	buf := make([]byte, 64)
+	for {
+		n, err := c.Read(buf)
+		if err == io.EOF {
+			return sizes
+		}
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		sizes = append(sizes, n)
+	}
+}
+
+func TestChaosDeterministic(t *testing.T) {
+	first := shortReads(t, 42)
+	second := shortReads(t, 42)
+
+	if len(first) < 2 {
+		t.Errorf("expected short reads, got %v", first)
+	}
+	if len(first) != len(second) {
+		t.Fatalf("same seed gave %v and %v", first, second)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("same seed gave %v and %v", first, second)
+		}
+	}
+}
+
+func TestChaosReset(t *testing.T) {
+	ch := NewChaos(ChaosConfig{ResetRate: 1})
+	a, _ := Pipe(Addr{Domain: 1, Port: 1}, Addr{Domain: 0, Port: 2})
+	c := ch.Conn(a)
+
+	if _, err := c.Write([]byte("x")); err != ErrChaosReset {
+		t.Errorf("Write error %v, want %v", err, ErrChaosReset)
+	}
+	if _, err := a.Write([]byte("x")); err == nil {
+		t.Errorf("underlying connection not closed on reset")
+	}
+}
+
+func TestChaosShortWrite(t *testing.T) {
+	ch := NewChaos(ChaosConfig{ShortIORate: 1})
+	a, b := Pipe(Addr{Domain: 1, Port: 1}, Addr{Domain: 0, Port: 2})
+	c := ch.Conn(a)
+
+	go io.Copy(ioutil.Discard, b)
+
+	n, err := c.Write([]byte("hello"))
+	if err != io.ErrShortWrite || n >= 5 {
+		t.Errorf("Write = %d, %v, want short write", n, err)
+	}
+}
+
+func TestChaosAcceptFail(t *testing.T) {
+	ch := NewChaos(ChaosConfig{AcceptFailRate: 1})
+	pl := NewPipeListener(Addr{Domain: 0, Port: 5555})
+	defer pl.Close()
+	l := ch.Listener(pl)
+
+	go pl.Dial(Addr{Domain: 3, Port: 1000})
+
+	if _, err := l.Accept(); err != ErrChaosAccept {
+		t.Errorf("Accept error %v, want %v", err, ErrChaosAccept)
+	}
+}
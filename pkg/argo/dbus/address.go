@@ -0,0 +1,198 @@
+package dbus
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Address is one entry of a D-Bus server address, a transport name and its
+// unescaped key/value parameters.
+type Address struct {
+	Transport string
+	Params    map[string]string
+
+	// raw is the entry as parsed, see Raw
+	raw string
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+
+	return 0, false
+}
+
+func unescapeValue(s string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("truncated escape in %q", s)
+		}
+		hi, ok1 := unhex(s[i+1])
+		lo, ok2 := unhex(s[i+2])
+		if !ok1 || !ok2 {
+			return "", fmt.Errorf("invalid escape %q in %q", s[i:i+3], s)
+		}
+		b.WriteByte(hi<<4 | lo)
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+func isOptionallyEscaped(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') ||
+		c == '-' || c == '_' || c == '/' || c == '\\' || c == '.' || c == '*'
+}
+
+func escapeValue(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if isOptionallyEscaped(s[i]) {
+			b.WriteByte(s[i])
+		} else {
+			fmt.Fprintf(&b, "%%%02x", s[i])
+		}
+	}
+
+	return b.String()
+}
+
+// ParseAddress parses a single address entry, `transport:key=value,...`.
+func ParseAddress(s string) (Address, error) {
+	a := Address{Params: make(map[string]string), raw: s}
+
+	i := strings.IndexRune(s, ':')
+	if i == -1 {
+		return a, fmt.Errorf("dbus: invalid bus address %q (no transport)", s)
+	}
+	if i == 0 {
+		return a, fmt.Errorf("dbus: invalid bus address %q (empty transport)", s)
+	}
+	a.Transport = s[:i]
+
+	if s[i+1:] == "" {
+		return a, nil
+	}
+
+	for _, f := range strings.Split(s[i+1:], ",") {
+		j := strings.IndexRune(f, '=')
+		if j == -1 {
+			return a, fmt.Errorf("dbus: invalid bus address %q (field %q has no value)", s, f)
+		}
+
+		key := f[:j]
+		if key == "" {
+			return a, fmt.Errorf("dbus: invalid bus address %q (field %q has no key)", s, f)
+		}
+		if _, ok := a.Params[key]; ok {
+			return a, fmt.Errorf("dbus: invalid bus address %q (duplicate key %q)", s, key)
+		}
+
+		v, err := unescapeValue(f[j+1:])
+		if err != nil {
+			return a, fmt.Errorf("dbus: invalid bus address: %v", err)
+		}
+		a.Params[key] = v
+	}
+
+	return a, nil
+}
+
+// ParseAddresses parses a ';' separated list of addresses, in the order the
+// client should try them.
+func ParseAddresses(s string) ([]Address, error) {
+	var addrs []Address
+
+	for _, entry := range strings.Split(s, ";") {
+		if entry == "" {
+			continue
+		}
+
+		a, err := ParseAddress(entry)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a)
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("dbus: invalid bus address %q (empty)", s)
+	}
+
+	return addrs, nil
+}
+
+// String returns the escaped form of the address with keys in sorted order.
+func (a Address) String() string {
+	keys := make([]string, 0, len(a.Params))
+	for k := range a.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]string, len(keys))
+	for i, k := range keys {
+		fields[i] = k + "=" + escapeValue(a.Params[k])
+	}
+
+	return a.Transport + ":" + strings.Join(fields, ",")
+}
+
+// Raw returns the entry as it was parsed, or String for an Address built
+// rather than parsed. godbus does not unescape address values, so this is
+// the form to hand it.
+func (a Address) Raw() string {
+	if a.raw == "" {
+		return a.String()
+	}
+
+	return a.raw
+}
+
+// argoParams returns the domain and port of an argo address, accepting
+// either "domain" or "domid" for the domain key.
+func (a Address) argoParams() (int, int, error) {
+	dom, hasDomain := a.Params["domain"]
+	if domid, ok := a.Params["domid"]; ok {
+		if hasDomain && domid != dom {
+			return 0, 0, fmt.Errorf("dbus: argo address %s has conflicting domain and domid", a)
+		}
+		dom, hasDomain = domid, true
+	}
+	if !hasDomain {
+		return 0, 0, fmt.Errorf("dbus: argo address %s has no domain", a)
+	}
+
+	port, ok := a.Params["port"]
+	if !ok {
+		return 0, 0, fmt.Errorf("dbus: argo address %s has no port", a)
+	}
+
+	d, err := strconv.ParseUint(dom, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("dbus: argo address %s has invalid domain %q", a, dom)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("dbus: argo address %s has invalid port %q", a, port)
+	}
+
+	return int(d), int(p), nil
+}
@@ -0,0 +1,131 @@
+package dbus
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAddresses(t *testing.T) {
+	addrs, err := ParseAddresses("argo:domid=0,port=5555;unix:path=/var/run/dbus%20socket;")
+	if err != nil {
+		t.Fatalf("ParseAddresses error: %v", err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("got %d addresses, want 2", len(addrs))
+	}
+
+	domid, port, err := addrs[0].argoParams()
+	if err != nil || domid != 0 || port != 5555 {
+		t.Errorf("argoParams = %d, %d, %v, want 0, 5555", domid, port, err)
+	}
+
+	if p := addrs[1].Params["path"]; p != "/var/run/dbus socket" {
+		t.Errorf("path = %q, want unescaped space", p)
+	}
+	if s := addrs[1].String(); s != "unix:path=/var/run/dbus%20socket" {
+		t.Errorf("String = %q", s)
+	}
+}
+
+func TestParseAddressErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		";",
+		"nocolon",
+		":path=/tmp",
+		"unix:path",
+		"unix:=x",
+		"unix:path=a,path=b",
+		"unix:path=%2",
+		"unix:path=%zz",
+	} {
+		if _, err := ParseAddresses(s); err == nil {
+			t.Errorf("ParseAddresses(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestArgoParamsErrors(t *testing.T) {
+	for _, s := range []string{
+		"argo:port=5555",
+		"argo:domain=0",
+		"argo:domain=x,port=5555",
+		"argo:domain=0,port=-1",
+		"argo:domain=0,domid=1,port=5555",
+	} {
+		a, err := ParseAddress(s)
+		if err != nil {
+			t.Fatalf("ParseAddress(%q) error: %v", s, err)
+		}
+		if _, _, err := a.argoParams(); err == nil {
+			t.Errorf("argoParams(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestDialRawAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// godbus does not unescape values, so characters String would escape
+	// must reach it as written
+	path := filepath.Join(dir, "bus+1~x")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	a, err := ParseAddress("unix:path=" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Raw() != "unix:path="+path {
+		t.Errorf("Raw = %q", a.Raw())
+	}
+
+	conn, err := Dial("unix:path=" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if a, _ := ParseAddress("tcp:host=::1,port=1"); a.Raw() != "tcp:host=::1,port=1" {
+		t.Errorf("Raw = %q", a.Raw())
+	}
+}
+
+func TestDialTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bus")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The first address fails, the second is used
+	c, err := DialTransport("unix:path=" + filepath.Join(dir, "missing") + ";unix:path=" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if _, err := DialTransport("argo:domain=0,domid=1,port=5555"); err == nil {
+		t.Errorf("DialTransport accepted conflicting domain and domid")
+	}
+	if _, err := DialTransport("nonce-tcp:host=localhost,port=1"); err == nil {
+		t.Errorf("DialTransport accepted an unsupported transport")
+	}
+}
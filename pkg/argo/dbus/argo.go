@@ -2,8 +2,9 @@ package dbus
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"os"
-	"strconv"
 	"strings"
 	"syscall"
 
@@ -16,66 +17,169 @@ const (
 	SystemBusAddress = "unix:path=/var/run/dbus/system_bus_socket"
 )
 
-func ConnectPlatformBus(opts ...godbus.ConnOption) (*godbus.Conn, error) {
+func platformBusAddress() string {
 	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
 	if address == "" {
 		address = PlatformBusAddress
 	}
 
-	return Connect(address, opts...)
+	return address
 }
 
-// Connect expands the godbus Connect to accept argo address string
-//   address is of the format: `argo:domid={id},port={number}`
-func Connect(address string, opts ...godbus.ConnOption) (*godbus.Conn, error) {
-	var conn *godbus.Conn
+func ConnectPlatformBus(opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return Connect(platformBusAddress(), opts...)
+}
+
+// ConnectPlatformBusAuth connects to the platform bus authenticating with
+// the given mechanisms, see ConnectAuth.
+func ConnectPlatformBusAuth(methods []godbus.Auth, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return ConnectAuth(platformBusAddress(), methods, opts...)
+}
+
+// dial opens the transport of a single parsed address, also returning the
+// argo connection underneath, which is nil for transports godbus dials
+func dial(a Address, opts ...godbus.ConnOption) (*godbus.Conn, net.Conn, error) {
+	if a.Transport != "argo" {
+		conn, err := godbus.Dial(a.Raw(), opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dbus: unable to connect to %s: %v", a, err)
+		}
+		return conn, nil, nil
+	}
+
+	c, err := dialTransport(a)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := godbus.NewConn(c, opts...)
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+
+	return conn, c, nil
+}
+
+// dialTransport opens the unix, tcp or argo transport of a single parsed
+// address
+func dialTransport(a Address) (net.Conn, error) {
+	var c net.Conn
 	var err error
 
-	i := strings.IndexRune(address, ':')
-	if i == -1 {
-		err = errors.New("dbus: invalid bus address (no transport)")
+	switch a.Transport {
+	case "unix":
+		switch {
+		case a.Params["path"] != "":
+			c, err = net.Dial("unix", a.Params["path"])
+		case a.Params["abstract"] != "":
+			c, err = net.Dial("unix", "@"+a.Params["abstract"])
+		default:
+			return nil, fmt.Errorf("dbus: unix address %s has no path", a)
+		}
+	case "tcp":
+		c, err = net.Dial("tcp", net.JoinHostPort(a.Params["host"], a.Params["port"]))
+	case "argo":
+		domid, port, perr := a.argoParams()
+		if perr != nil {
+			return nil, perr
+		}
+		var ac *argo.Conn
+		if ac, err = argo.Dial(syscall.SOCK_STREAM, domid, port); err == nil {
+			c = ac
+		}
+	default:
+		return nil, fmt.Errorf("dbus: unsupported transport in %s", a)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbus: unable to connect to %s: %v", a, err)
+	}
+
+	return c, nil
+}
+
+// DialTransport opens the transport of the first reachable ';' separated
+// address without speaking D-Bus on it, for relays that forward messages
+// unchanged, see ClientAuth. Only unix, tcp and argo addresses are
+// supported.
+func DialTransport(address string) (net.Conn, error) {
+	addrs, err := ParseAddresses(address)
+	if err != nil {
 		return nil, err
 	}
 
-	if address[:i] == "argo" {
-		var domid, port int
-
-		fields := strings.Split(address[i+1:], ",")
-		for _, f := range fields {
-			i := strings.IndexRune(f, '=')
-			switch f[:i] {
-			case "domain":
-				domid,_ = strconv.Atoi(f[i+1:])
-			case "port":
-				port,_ = strconv.Atoi(f[i+1:])
-			default:
-			}
+	var failed []string
+	for _, a := range addrs {
+		c, err := dialTransport(a)
+		if err == nil {
+			return c, nil
 		}
+		failed = append(failed, err.Error())
+	}
 
-		c, err := argo.Dial(syscall.SOCK_STREAM, domid, port)
-		if err != nil {
-			return nil, err
-		}
+	return nil, errors.New(strings.Join(failed, "; "))
+}
 
-		conn, err = godbus.NewConn(c.File(), opts...)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		conn, err = godbus.Dial(address, opts...)
-		if err != nil {
-			return nil, err
+// dialAddresses tries each ';' separated address in order, see dial
+func dialAddresses(address string, opts ...godbus.ConnOption) (*godbus.Conn, net.Conn, error) {
+	addrs, err := ParseAddresses(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var failed []string
+	for _, a := range addrs {
+		conn, c, err := dial(a, opts...)
+		if err == nil {
+			return conn, c, nil
 		}
+		failed = append(failed, err.Error())
 	}
 
-	// Should pass conn.auth but it is private and cannot access
-	if err = conn.Auth([]godbus.Auth{godbus.AuthAnonymous(),godbus.AuthExternal("root")}); err != nil {
-		_ = conn.Close()
+	return nil, nil, errors.New(strings.Join(failed, "; "))
+}
+
+// Dial is the equivalent of godbus.Dial that also accepts argo addresses,
+// opening a private connection that must be authenticated and sent Hello
+// before use. Each ';' separated address is tried in order.
+func Dial(address string, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	conn, _, err := dialAddresses(address, opts...)
+
+	return conn, err
+}
+
+// Connect expands the godbus Connect to accept argo address string
+//   address is of the format: `argo:domain={id},port={number}`, with
+//   `domid` accepted in place of `domain`. Multiple addresses may be
+//   separated by ';' and are tried in order until one connects. The
+//   connection authenticates with DefaultAuth.
+func Connect(address string, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return ConnectAuth(address, nil, opts...)
+}
+
+// ConnectAuth is Connect authenticating with the given mechanisms, tried in
+// order, or DefaultAuth if methods is nil. The platform bus policy applies
+// to the identity claimed here, e.g. AnonymousAuth to connect unprivileged.
+func ConnectAuth(address string, methods []godbus.Auth, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	conn, err := Dial(address, opts...)
+	if err != nil {
 		return nil, err
 	}
-	if err = conn.Hello(); err != nil {
+
+	if err = setup(conn, methods); err != nil {
 		_ = conn.Close()
 		return nil, err
 	}
 	return conn, nil
 }
+
+// setup authenticates a dialed connection and sends Hello
+func setup(conn *godbus.Conn, methods []godbus.Auth) error {
+	if methods == nil {
+		methods = DefaultAuth()
+	}
+	if err := conn.Auth(methods); err != nil {
+		return err
+	}
+	return conn.Hello()
+}
@@ -0,0 +1,116 @@
+package dbus
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// DefaultKeyringDir returns the keyring directory of the current user,
+// `$HOME/.dbus-keyrings`.
+func DefaultKeyringDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = "/"
+	}
+
+	return filepath.Join(home, ".dbus-keyrings")
+}
+
+// DefaultAuth returns the mechanisms used when none are given, EXTERNAL and
+// DBUS_COOKIE_SHA1 as the real uid of the process.
+func DefaultAuth() []godbus.Auth {
+	return []godbus.Auth{AuthExternal(), AuthCookieSha1(DefaultKeyringDir())}
+}
+
+// AnonymousAuth returns the mechanisms to connect without claiming an
+// identity.
+func AnonymousAuth() []godbus.Auth {
+	return []godbus.Auth{godbus.AuthAnonymous()}
+}
+
+// AuthExternal returns the EXTERNAL mechanism for the real uid of the
+// process.
+func AuthExternal() godbus.Auth {
+	return godbus.AuthExternal(strconv.Itoa(os.Getuid()))
+}
+
+// AuthCookieSha1 returns the DBUS_COOKIE_SHA1 mechanism for the real uid of
+// the process, reading cookies from keyringDir rather than the fixed
+// location under the home directory used by godbus.
+func AuthCookieSha1(keyringDir string) godbus.Auth {
+	return authCookieSha1{user: strconv.Itoa(os.Getuid()), dir: keyringDir}
+}
+
+type authCookieSha1 struct {
+	user, dir string
+}
+
+func (a authCookieSha1) FirstData() ([]byte, []byte, godbus.AuthStatus) {
+	b := make([]byte, hex.EncodedLen(len(a.user)))
+	hex.Encode(b, []byte(a.user))
+	return []byte("DBUS_COOKIE_SHA1"), b, godbus.AuthContinue
+}
+
+func (a authCookieSha1) HandleData(data []byte) ([]byte, godbus.AuthStatus) {
+	challenge := make([]byte, hex.DecodedLen(len(data)))
+	if _, err := hex.Decode(challenge, data); err != nil {
+		return nil, godbus.AuthError
+	}
+
+	// The server challenge is `<context> <cookie id> <challenge>`
+	b := bytes.Split(challenge, []byte{' '})
+	if len(b) != 3 {
+		return nil, godbus.AuthError
+	}
+
+	cookie := a.cookie(string(b[0]), b[1])
+	if cookie == nil {
+		return nil, godbus.AuthError
+	}
+
+	r := make([]byte, 16)
+	if _, err := rand.Read(r); err != nil {
+		return nil, godbus.AuthError
+	}
+	clchallenge := []byte(hex.EncodeToString(r))
+
+	hash := sha1.Sum(bytes.Join([][]byte{b[2], clchallenge, cookie}, []byte{':'}))
+
+	resp := append(clchallenge, ' ')
+	resp = append(resp, hex.EncodeToString(hash[:])...)
+
+	return []byte(hex.EncodeToString(resp)), godbus.AuthOk
+}
+
+// cookie looks up id in the keyring for context, where each line of the
+// keyring is `<id> <creation time> <cookie>`.
+func (a authCookieSha1) cookie(context string, id []byte) []byte {
+	// The context names a file in the keyring directory
+	if context == "" || context != filepath.Base(context) || context[0] == '.' {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(a.dir, context))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := bytes.Fields(s.Bytes())
+		if len(fields) == 3 && bytes.Equal(fields[0], id) {
+			return fields[2]
+		}
+	}
+
+	return nil
+}
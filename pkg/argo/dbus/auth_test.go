@@ -0,0 +1,116 @@
+package dbus
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+func TestAuthCookieSha1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := "1 1600000000 deadbeef\n7 1600000000 c0ffee\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "org_freedesktop_general"), []byte(keyring), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := AuthCookieSha1(dir)
+	name, _, status := a.FirstData()
+	if string(name) != "DBUS_COOKIE_SHA1" || status != godbus.AuthContinue {
+		t.Fatalf("FirstData = %s, %v", name, status)
+	}
+
+	challenge := []byte(hex.EncodeToString([]byte("org_freedesktop_general 7 srvchallenge")))
+	resp, status := a.HandleData(challenge)
+	if status != godbus.AuthOk {
+		t.Fatalf("HandleData status %v", status)
+	}
+
+	data, err := hex.DecodeString(string(resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := bytes.Split(data, []byte{' '})
+	if len(f) != 2 {
+		t.Fatalf("response %q is not `<challenge> <hash>`", data)
+	}
+
+	hash := sha1.Sum([]byte("srvchallenge:" + string(f[0]) + ":c0ffee"))
+	if string(f[1]) != hex.EncodeToString(hash[:]) {
+		t.Errorf("response hash %s does not match cookie", f[1])
+	}
+
+	bad := []byte(hex.EncodeToString([]byte("../etc 7 srvchallenge")))
+	if _, status := a.HandleData(bad); status != godbus.AuthError {
+		t.Errorf("HandleData accepted context outside the keyring")
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	a, b := argo.Pipe(argo.Addr{Domain: 1, Port: 1000}, argo.Addr{Domain: 0, Port: 5555})
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ServerAuth(b)
+		done <- err
+	}()
+
+	// The server rejects DBUS_COOKIE_SHA1, so EXTERNAL is tried next
+	methods := []godbus.Auth{AuthCookieSha1(DefaultKeyringDir()), AuthExternal()}
+	if err := ClientAuth(a, methods); err != nil {
+		t.Fatalf("ClientAuth error: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ServerAuth error: %v", err)
+	}
+
+	// Nothing past BEGIN was consumed by either side
+	go a.Write([]byte("l"))
+	p := make([]byte, 1)
+	if _, err := b.Read(p); err != nil || p[0] != 'l' {
+		t.Errorf("read %q, %v after handshake", p, err)
+	}
+}
+
+func TestHandshakeShim(t *testing.T) {
+	a, b := argo.Pipe(argo.Addr{Domain: 0, Port: 5555}, argo.Addr{Domain: 1, Port: 1000})
+	defer a.Close()
+	defer b.Close()
+
+	s := newHandshakeShim(a, "0123")
+	exchange := func(line, want string) {
+		if _, err := s.Write([]byte(line)); err != nil {
+			t.Fatalf("write %q: %v", line, err)
+		}
+		p := make([]byte, 64)
+		n, err := s.Read(p)
+		if err != nil || string(p[:n]) != want {
+			t.Fatalf("reply to %q = %q, %v, want %q", line, p[:n], err, want)
+		}
+	}
+
+	// A client other than godbus, asking for data before sending it
+	exchange("\x00AUTH\r\n", "REJECTED EXTERNAL ANONYMOUS\r\n")
+	exchange("AUTH EXTERNAL\r\n", "DATA\r\n")
+	exchange("DATA 30\r\n", "OK 0123\r\n")
+
+	// Message data following BEGIN in the same write reaches the peer
+	go s.Write([]byte("BEGIN\r\nl"))
+	p := make([]byte, 1)
+	if _, err := b.Read(p); err != nil || p[0] != 'l' {
+		t.Errorf("read %q, %v after handshake", p, err)
+	}
+}
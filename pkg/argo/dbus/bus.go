@@ -0,0 +1,117 @@
+package dbus
+
+import (
+	"os"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// godbus keeps its transport table private so argo cannot be registered
+// with it. The functions here mirror the godbus bus helpers, routing every
+// address through Dial so that "argo:" addresses, including those taken
+// from DBUS_SYSTEM_BUS_ADDRESS and DBUS_SESSION_BUS_ADDRESS, work wherever
+// the godbus equivalent would be used.
+
+func systemBusAddress() string {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = SystemBusAddress
+	}
+
+	return address
+}
+
+// SystemBusPrivate returns a new private connection to the system bus, as
+// with Dial the connection must be authenticated and sent Hello before use.
+func SystemBusPrivate(opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return Dial(systemBusAddress(), opts...)
+}
+
+// SessionBusPrivate returns a new private connection to the session bus,
+// falling back to godbus session bus discovery when DBUS_SESSION_BUS_ADDRESS
+// is not set.
+func SessionBusPrivate(opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address == "" || address == "autolaunch:" {
+		return godbus.SessionBusPrivate(opts...)
+	}
+
+	return Dial(address, opts...)
+}
+
+// PlatformBusPrivate returns a new private connection to the platform bus.
+func PlatformBusPrivate(opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return Dial(platformBusAddress(), opts...)
+}
+
+// ConnectSystemBus connects to the system bus, authenticating with
+// DefaultAuth and sending Hello.
+func ConnectSystemBus(opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return Connect(systemBusAddress(), opts...)
+}
+
+// ConnectSessionBus connects to the session bus, authenticating with
+// DefaultAuth and sending Hello.
+func ConnectSessionBus(opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	conn, err := SessionBusPrivate(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = setup(conn, nil); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sharedBus holds a connection shared by the process, replaced on the next
+// call after it is closed.
+type sharedBus struct {
+	mu   sync.Mutex
+	conn *godbus.Conn
+}
+
+func (b *sharedBus) get(connect func(...godbus.ConnOption) (*godbus.Conn, error)) (*godbus.Conn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn != nil {
+		select {
+		case <-b.conn.Context().Done():
+			b.conn = nil
+		default:
+			return b.conn, nil
+		}
+	}
+
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+
+	return conn, nil
+}
+
+var systemBus, sessionBus, platformBus sharedBus
+
+// SystemBus returns a shared connection to the system bus, connecting to it
+// if not already done. The connection must not be closed by the caller.
+func SystemBus() (*godbus.Conn, error) {
+	return systemBus.get(ConnectSystemBus)
+}
+
+// SessionBus returns a shared connection to the session bus, connecting to
+// it if not already done. The connection must not be closed by the caller.
+func SessionBus() (*godbus.Conn, error) {
+	return sessionBus.get(ConnectSessionBus)
+}
+
+// PlatformBus returns a shared connection to the platform bus, connecting
+// to it if not already done. The connection must not be closed by the
+// caller.
+func PlatformBus() (*godbus.Conn, error) {
+	return platformBus.get(ConnectPlatformBus)
+}
@@ -0,0 +1,95 @@
+package dbus
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// ConnectContext is Connect bounded by ctx, see ConnectAuthContext.
+func ConnectContext(ctx context.Context, address string, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return ConnectAuthContext(ctx, address, nil, opts...)
+}
+
+// ConnectPlatformBusContext is ConnectPlatformBus bounded by ctx.
+func ConnectPlatformBusContext(ctx context.Context, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	return ConnectAuthContext(ctx, platformBusAddress(), nil, opts...)
+}
+
+// ConnectAuthContext is ConnectAuth bounded by ctx. If ctx is done before
+// dialing, SASL and Hello complete, ctx.Err() is returned and the partially
+// set up connection is closed. A dial still in progress is closed once it
+// returns.
+func ConnectAuthContext(ctx context.Context, address string, methods []godbus.Auth, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var partial *godbus.Conn
+	var transport net.Conn
+	var cancelled bool
+
+	type result struct {
+		conn *godbus.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		conn, c, err := dialAddresses(address, opts...)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		mu.Lock()
+		if cancelled {
+			mu.Unlock()
+			conn.Close()
+			done <- result{err: ctx.Err()}
+			return
+		}
+		partial, transport = conn, c
+		mu.Unlock()
+
+		if err = setup(conn, methods); err != nil {
+			conn.Close()
+			done <- result{err: err}
+			return
+		}
+		done <- result{conn: conn}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+	}
+
+	// An expired deadline fails any read or write SASL and Hello are
+	// blocked in on an argo connection without relying on close to do so.
+	// Neither works on a descriptor outside the runtime poller, see
+	// argo.Conn.Fd, and the goroutine then stays blocked until the driver
+	// returns. Either way it cleans up whatever it finishes with.
+	mu.Lock()
+	cancelled = true
+	if transport != nil {
+		transport.SetDeadline(time.Now())
+	}
+	if partial != nil {
+		partial.Close()
+	}
+	mu.Unlock()
+
+	go func() {
+		if r := <-done; r.conn != nil {
+			r.conn.Close()
+		}
+	}()
+
+	return nil, ctx.Err()
+}
@@ -0,0 +1,61 @@
+package dbus
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A server that accepts but never answers SASL must not hang the caller
+func TestConnectContextTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "socket")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = ConnectContext(ctx, "unix:path="+path)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("ConnectContext error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("ConnectContext returned after %v", d)
+	}
+
+	// The partial connection is closed, so the server sees EOF
+	c := <-accepted
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 256)
+	for {
+		if _, err := c.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Errorf("partial connection was not closed")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package dbus
+
+import (
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// Interceptors is implemented by Tracer and Recorder, which observe the
+// messages of the connections they are installed on.
+type Interceptors interface {
+	// interceptors returns the incoming and outgoing interceptors for one
+	// new connection
+	interceptors() (godbus.Interceptor, godbus.Interceptor)
+}
+
+var (
+	_ Interceptors = (*Tracer)(nil)
+	_ Interceptors = (*Recorder)(nil)
+)
+
+// WithInterceptors installs the interceptors of each of its arguments on a
+// connection, calling them in order for every message. godbus keeps a single
+// incoming and outgoing interceptor per connection, so WithTracer and
+// WithRecorder replace each other where this combines them, e.g.
+//
+//   conn, err := dbus.Connect(address, dbus.WithInterceptors(t, r))
+func WithInterceptors(is ...Interceptors) godbus.ConnOption {
+	return func(conn *godbus.Conn) error {
+		var incoming, outgoing []godbus.Interceptor
+		for _, i := range is {
+			in, out := i.interceptors()
+			incoming = append(incoming, in)
+			outgoing = append(outgoing, out)
+		}
+
+		if err := godbus.WithIncomingInterceptor(chain(incoming))(conn); err != nil {
+			return err
+		}
+		return godbus.WithOutgoingInterceptor(chain(outgoing))(conn)
+	}
+}
+
+func chain(interceptors []godbus.Interceptor) godbus.Interceptor {
+	if len(interceptors) == 1 {
+		return interceptors[0]
+	}
+
+	return func(msg *godbus.Message) {
+		for _, i := range interceptors {
+			i(msg)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package dbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+var ErrManagedClosed = errors.New("dbus: managed connection closed")
+
+// ManagedConfig configures a ManagedConn. The zero value connects to the
+// platform bus with DefaultAuth.
+type ManagedConfig struct {
+	// Address of the bus, defaults to the platform bus address
+	Address string
+
+	// Auth mechanisms, defaults to DefaultAuth
+	Auth []godbus.Auth
+
+	// Options applied to every underlying connection
+	Options []godbus.ConnOption
+
+	// MinBackoff and MaxBackoff bound the delay between redial attempts,
+	// which doubles after each failure
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnReconnect is called with the new connection after it has been
+	// restored, from the goroutine watching the connection
+	OnReconnect func(conn *godbus.Conn)
+}
+
+type ownedName struct {
+	name  string
+	flags godbus.RequestNameFlags
+}
+
+// ManagedConn is a bus connection that survives the bus going away. When
+// the underlying connection drops it is redialed, Hello is sent and the
+// match rules, signal channels and names registered through the
+// ManagedConn are restored. Calls made while disconnected fail as they
+// would on a closed *godbus.Conn.
+type ManagedConn struct {
+	cfg  ManagedConfig
+	done chan struct{}
+
+	mu      sync.Mutex
+	conn    *godbus.Conn
+	matches [][]godbus.MatchOption
+	names   []ownedName
+
+	sigMu   sync.RWMutex
+	signals []*signalQueue
+}
+
+// signalQueue buffers the signals for one channel in its own goroutine, as
+// godbus's default handler does, so that a slow reader never stalls the
+// connection delivering to it.
+type signalQueue struct {
+	ch      chan<- *godbus.Signal
+	in      chan *godbus.Signal
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newSignalQueue(ch chan<- *godbus.Signal) *signalQueue {
+	q := &signalQueue{
+		ch:      ch,
+		in:      make(chan *godbus.Signal),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go q.run()
+
+	return q
+}
+
+func (q *signalQueue) run() {
+	defer close(q.stopped)
+
+	var queue []*godbus.Signal
+	for {
+		var out chan<- *godbus.Signal
+		var next *godbus.Signal
+		if len(queue) > 0 {
+			out, next = q.ch, queue[0]
+		}
+
+		select {
+		case sig := <-q.in:
+			queue = append(queue, sig)
+		case out <- next:
+			queue[0] = nil
+			queue = queue[1:]
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// deliver hands sig to the queue goroutine, which is always ready to take
+// it until the queue is stopped.
+func (q *signalQueue) deliver(sig *godbus.Signal) {
+	select {
+	case q.in <- sig:
+	case <-q.stop:
+	}
+}
+
+// NewManagedConn makes the initial connection, failing if it cannot be
+// established, and starts watching it.
+func NewManagedConn(cfg ManagedConfig) (*ManagedConn, error) {
+	if cfg.Address == "" {
+		cfg.Address = platformBusAddress()
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+
+	mc := &ManagedConn{cfg: cfg, done: make(chan struct{})}
+
+	conn, err := mc.connect()
+	if err != nil {
+		return nil, err
+	}
+	mc.conn = conn
+
+	go mc.watch(conn)
+
+	return mc, nil
+}
+
+func (mc *ManagedConn) connect() (*godbus.Conn, error) {
+	// Signals are delivered through the ManagedConn so the application's
+	// channels are not closed with each underlying connection
+	opts := append([]godbus.ConnOption{}, mc.cfg.Options...)
+	opts = append(opts, godbus.WithSignalHandler(mc))
+
+	return ConnectAuth(mc.cfg.Address, mc.cfg.Auth, opts...)
+}
+
+func (mc *ManagedConn) watch(conn *godbus.Conn) {
+	for {
+		select {
+		case <-conn.Context().Done():
+		case <-mc.done:
+			return
+		}
+
+		conn = mc.reconnect()
+		if conn == nil {
+			return
+		}
+
+		if mc.cfg.OnReconnect != nil {
+			mc.cfg.OnReconnect(conn)
+		}
+	}
+}
+
+// reconnect redials until a connection is restored, returning nil if the
+// ManagedConn is closed first.
+func (mc *ManagedConn) reconnect() *godbus.Conn {
+	backoff := mc.cfg.MinBackoff
+
+	for {
+		conn, err := mc.connect()
+		if err == nil {
+			mc.mu.Lock()
+			err = mc.restore(conn)
+			if err == nil {
+				select {
+				case <-mc.done:
+					mc.mu.Unlock()
+					conn.Close()
+					return nil
+				default:
+				}
+				mc.conn = conn
+				mc.mu.Unlock()
+				return conn
+			}
+			mc.mu.Unlock()
+			conn.Close()
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-mc.done:
+			return nil
+		}
+
+		backoff *= 2
+		if backoff > mc.cfg.MaxBackoff {
+			backoff = mc.cfg.MaxBackoff
+		}
+	}
+}
+
+func (mc *ManagedConn) restore(conn *godbus.Conn) error {
+	for _, m := range mc.matches {
+		if err := conn.AddMatchSignal(m...); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range mc.names {
+		if _, err := conn.RequestName(n.name, n.flags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Conn returns the current underlying connection, which is replaced after
+// a reconnect and so should not be held on to.
+func (mc *ManagedConn) Conn() *godbus.Conn {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.conn
+}
+
+// Object returns the object identified by dest and path on the current
+// connection.
+func (mc *ManagedConn) Object(dest string, path godbus.ObjectPath) godbus.BusObject {
+	return mc.Conn().Object(dest, path)
+}
+
+func matchEqual(a, b []godbus.MatchOption) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AddMatchSignal adds a match rule that is restored on reconnect.
+func (mc *ManagedConn) AddMatchSignal(options ...godbus.MatchOption) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if err := mc.conn.AddMatchSignal(options...); err != nil {
+		return err
+	}
+	mc.matches = append(mc.matches, options)
+
+	return nil
+}
+
+// RemoveMatchSignal removes a match rule added with AddMatchSignal.
+func (mc *ManagedConn) RemoveMatchSignal(options ...godbus.MatchOption) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for i, m := range mc.matches {
+		if matchEqual(m, options) {
+			mc.matches = append(mc.matches[:i], mc.matches[i+1:]...)
+			break
+		}
+	}
+
+	return mc.conn.RemoveMatchSignal(options...)
+}
+
+// RequestName requests name on the bus and again after each reconnect
+// until it is released with ReleaseName.
+func (mc *ManagedConn) RequestName(name string, flags godbus.RequestNameFlags) (godbus.RequestNameReply, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	reply, err := mc.conn.RequestName(name, flags)
+	if err != nil {
+		return reply, err
+	}
+
+	for i := range mc.names {
+		if mc.names[i].name == name {
+			mc.names[i].flags = flags
+			return reply, nil
+		}
+	}
+	mc.names = append(mc.names, ownedName{name: name, flags: flags})
+
+	return reply, nil
+}
+
+// ReleaseName releases a name requested with RequestName.
+func (mc *ManagedConn) ReleaseName(name string) (godbus.ReleaseNameReply, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for i := range mc.names {
+		if mc.names[i].name == name {
+			mc.names = append(mc.names[:i], mc.names[i+1:]...)
+			break
+		}
+	}
+
+	return mc.conn.ReleaseName(name)
+}
+
+// Signal registers ch to receive signals across reconnects, as with
+// godbus.Conn.Signal. The channel is closed when the ManagedConn is closed.
+// Signals are queued without limit for each channel, so a channel that is
+// never read holds on to every signal sent until it is removed.
+func (mc *ManagedConn) Signal(ch chan<- *godbus.Signal) {
+	mc.sigMu.Lock()
+	defer mc.sigMu.Unlock()
+
+	select {
+	case <-mc.done:
+		close(ch)
+		return
+	default:
+	}
+
+	mc.signals = append(mc.signals, newSignalQueue(ch))
+}
+
+// RemoveSignal stops delivering signals to ch, dropping any still queued.
+func (mc *ManagedConn) RemoveSignal(ch chan<- *godbus.Signal) {
+	mc.sigMu.Lock()
+	defer mc.sigMu.Unlock()
+
+	for i := len(mc.signals) - 1; i >= 0; i-- {
+		if mc.signals[i].ch == ch {
+			close(mc.signals[i].stop)
+			mc.signals = append(mc.signals[:i], mc.signals[i+1:]...)
+		}
+	}
+}
+
+// DeliverSignal implements godbus.SignalHandler for the underlying
+// connections. It is called from the connection's reader and only queues
+// the signal, never waiting on the application.
+func (mc *ManagedConn) DeliverSignal(intf, name string, signal *godbus.Signal) {
+	mc.sigMu.RLock()
+	defer mc.sigMu.RUnlock()
+
+	for _, q := range mc.signals {
+		q.deliver(signal)
+	}
+}
+
+// Close closes the current connection, stops reconnecting and closes the
+// registered signal channels.
+func (mc *ManagedConn) Close() error {
+	mc.mu.Lock()
+	select {
+	case <-mc.done:
+		mc.mu.Unlock()
+		return ErrManagedClosed
+	default:
+	}
+	close(mc.done)
+	err := mc.conn.Close()
+	mc.mu.Unlock()
+
+	mc.sigMu.Lock()
+	for _, q := range mc.signals {
+		close(q.stop)
+		<-q.stopped
+		close(q.ch)
+	}
+	mc.signals = nil
+	mc.sigMu.Unlock()
+
+	return err
+}
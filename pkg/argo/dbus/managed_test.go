@@ -0,0 +1,184 @@
+package dbus
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+// matchBus is a testBus that reports the match rules added to it
+type matchBus struct {
+	testBus
+	matches chan string
+}
+
+func (b matchBus) AddMatch(rule string) *godbus.Error {
+	b.matches <- rule
+	return nil
+}
+
+func (matchBus) RequestName(name string, flags uint32) (uint32, *godbus.Error) {
+	return uint32(godbus.RequestNameReplyPrimaryOwner), nil
+}
+
+// managedServer serves matchBus and echo on a unix socket, returning the
+// bus address and the server side of each connection made to it.
+func managedServer(t *testing.T) (string, <-chan *godbus.Conn, <-chan string, func()) {
+	dir, err := ioutil.TempDir("", "dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "bus")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make(chan *godbus.Conn, 4)
+	matches := make(chan string, 16)
+	s := &Server{
+		Setup: func(conn *godbus.Conn, peer argo.Addr) error {
+			if err := conn.Export(matchBus{matches: matches}, "/org/freedesktop/DBus", "org.freedesktop.DBus"); err != nil {
+				return err
+			}
+			if err := conn.Export(echo{}, "/echo", "org.openxt.Echo"); err != nil {
+				return err
+			}
+			conns <- conn
+			return nil
+		},
+	}
+	go s.Serve(l)
+
+	return "unix:path=" + path, conns, matches, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func managedConn(t *testing.T, address string) *ManagedConn {
+	mc, err := NewManagedConn(ManagedConfig{
+		Address:    address,
+		Auth:       AnonymousAuth(),
+		MinBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mc
+}
+
+func TestManagedSlowSubscriber(t *testing.T) {
+	address, conns, _, stop := managedServer(t)
+	defer stop()
+
+	mc := managedConn(t, address)
+	defer mc.Close()
+	server := <-conns
+
+	// A channel that is never read must not hold up method replies
+	stalled := make(chan *godbus.Signal)
+	mc.Signal(stalled)
+	for i := 0; i < 10; i++ {
+		server.Emit("/test", "org.openxt.Test.Changed", int32(i))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var s string
+		done <- mc.Object("", "/echo").Call("org.openxt.Echo.Echo", 0, "hi").Store(&s)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call blocked by an unread signal channel")
+	}
+
+	// Queued signals arrive in order once read
+	for i := 0; i < 10; i++ {
+		select {
+		case sig := <-stalled:
+			if sig.Body[0] != int32(i) {
+				t.Fatalf("signal %d carried %v", i, sig.Body[0])
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("signal %d not delivered", i)
+		}
+	}
+
+	server.Emit("/test", "org.openxt.Test.Changed", int32(10))
+	removed := make(chan struct{})
+	go func() {
+		mc.RemoveSignal(stalled)
+		close(removed)
+	}()
+	select {
+	case <-removed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RemoveSignal blocked by a pending delivery")
+	}
+}
+
+func TestManagedReconnect(t *testing.T) {
+	address, conns, matches, stop := managedServer(t)
+	defer stop()
+
+	reconnected := make(chan struct{}, 1)
+	mc, err := NewManagedConn(ManagedConfig{
+		Address:     address,
+		Auth:        AnonymousAuth(),
+		MinBackoff:  10 * time.Millisecond,
+		OnReconnect: func(*godbus.Conn) { reconnected <- struct{}{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := <-conns
+
+	ch := make(chan *godbus.Signal, 1)
+	mc.Signal(ch)
+	if err := mc.AddMatchSignal(godbus.WithMatchInterface("org.openxt.Test")); err != nil {
+		t.Fatal(err)
+	}
+	<-matches
+
+	first.Close()
+
+	var second *godbus.Conn
+	select {
+	case second = <-conns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("did not reconnect")
+	}
+	select {
+	case rule := <-matches:
+		if rule != "type='signal',interface='org.openxt.Test'" {
+			t.Errorf("restored match %q", rule)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("match not restored")
+	}
+	<-reconnected
+
+	second.Emit("/test", "org.openxt.Test.Changed", int32(1))
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("signal not delivered after reconnect")
+	}
+
+	mc.Close()
+	if _, ok := <-ch; ok {
+		t.Error("signal channel not closed")
+	}
+}
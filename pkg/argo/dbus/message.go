@@ -0,0 +1,26 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// EncodeWithSerial returns the little endian wire form of msg numbered
+// serial, for code writing messages to a transport itself rather than
+// through a godbus connection. godbus does not let the serial of a message
+// be set, so it is patched into the encoded header.
+func EncodeWithSerial(msg *godbus.Message, serial uint32) ([]byte, error) {
+	var b bytes.Buffer
+	if err := msg.EncodeTo(&b, binary.LittleEndian); err != nil {
+		return nil, err
+	}
+
+	// The serial follows the endianness, type, flags, version and body
+	// length fields of the fixed header
+	p := b.Bytes()
+	binary.LittleEndian.PutUint32(p[8:12], serial)
+
+	return p, nil
+}
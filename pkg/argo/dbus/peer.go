@@ -0,0 +1,68 @@
+package dbus
+
+import (
+	"net"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+// testBus answers the calls a client makes to the bus itself, so that
+// clients subscribing to signals work against a TestPeer.
+type testBus struct{}
+
+func (testBus) Hello() (string, *godbus.Error)        { return ":1.42", nil }
+func (testBus) AddMatch(rule string) *godbus.Error    { return nil }
+func (testBus) RemoveMatch(rule string) *godbus.Error { return nil }
+
+// pipeConn connects a client, created with opts, over an in-memory argo
+// pipe to serve, which runs the other end in its own goroutine.
+func pipeConn(serve func(c net.Conn), opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	a, b := argo.Pipe(argo.Addr{Domain: 1, Port: argo.XEN_ARGO_PORT_ANY}, argo.Addr{Domain: 0, Port: 5555})
+
+	go serve(b)
+
+	conn, err := godbus.NewConn(a, opts...)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := conn.Auth(AnonymousAuth()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// NewTestPeer connects a client, created with opts, to an in-process server
+// for testing clients of a service without a bus. The objects of the fake
+// service are exported on the server, which also answers Hello, AddMatch
+// and RemoveMatch as a bus would, and signals emitted on it reach the
+// client.
+func NewTestPeer(opts ...godbus.ConnOption) (client, server *godbus.Conn, err error) {
+	done := make(chan error, 1)
+	client, err = pipeConn(func(c net.Conn) {
+		conn, err := NewServerConn(c)
+		if err != nil {
+			c.Close()
+		}
+		server = conn
+		done <- err
+	}, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := <-done; err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	if err := server.Export(testBus{}, "/org/freedesktop/DBus", "org.freedesktop.DBus"); err != nil {
+		client.Close()
+		server.Close()
+		return nil, nil, err
+	}
+
+	return client, server, nil
+}
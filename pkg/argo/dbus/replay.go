@@ -0,0 +1,352 @@
+package dbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// recordingMagic starts every recording file
+const recordingMagic = "argo-dbus-recording 1\n"
+
+const (
+	recordOutgoing = 'o'
+	recordIncoming = 'i'
+)
+
+// Recorder writes the messages sent and received over the connections it is
+// installed on with WithRecorder. Each record is a direction byte followed
+// by the message in wire format.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	if _, err := io.WriteString(w, recordingMagic); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{w: w}, nil
+}
+
+// WithRecorder installs r as both the incoming and outgoing interceptor of
+// a connection, replacing any set by earlier options. Use WithInterceptors
+// to combine it with a Tracer.
+func WithRecorder(r *Recorder) godbus.ConnOption {
+	return WithInterceptors(r)
+}
+
+func (r *Recorder) interceptors() (godbus.Interceptor, godbus.Interceptor) {
+	incoming := func(msg *godbus.Message) { r.record(recordIncoming, msg) }
+	outgoing := func(msg *godbus.Message) { r.record(recordOutgoing, msg) }
+
+	return incoming, outgoing
+}
+
+func (r *Recorder) record(dir byte, msg *godbus.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	if _, r.err = r.w.Write([]byte{dir}); r.err != nil {
+		return
+	}
+	r.err = msg.EncodeTo(r.w, binary.LittleEndian)
+}
+
+// Err returns the first error writing the recording, after which nothing
+// more is recorded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// RecordedMessage is a message of a recording, Outgoing if the recorded
+// connection sent it.
+type RecordedMessage struct {
+	Outgoing bool
+	Message  *godbus.Message
+}
+
+// Recording is the sequence of messages recorded on a connection.
+type Recording struct {
+	Messages []RecordedMessage
+}
+
+// ReadRecording reads a recording written by a Recorder.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(recordingMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != recordingMagic {
+		return nil, errors.New("dbus: not a recording")
+	}
+
+	rec := &Recording{}
+	for {
+		dir, err := br.ReadByte()
+		if err == io.EOF {
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dir != recordOutgoing && dir != recordIncoming {
+			return nil, fmt.Errorf("dbus: recording message %d has invalid direction %q", len(rec.Messages), dir)
+		}
+
+		msg, err := godbus.DecodeMessage(br)
+		if err != nil {
+			return nil, fmt.Errorf("dbus: recording message %d: %v", len(rec.Messages), err)
+		}
+		rec.Messages = append(rec.Messages, RecordedMessage{Outgoing: dir == recordOutgoing, Message: msg})
+	}
+}
+
+// LoadRecording reads the recording in the named file.
+func LoadRecording(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadRecording(f)
+}
+
+func isCall(m RecordedMessage) bool {
+	return m.Outgoing && m.Message.Type == godbus.TypeMethodCall
+}
+
+func isReplyTo(m RecordedMessage, serial uint32) bool {
+	if m.Outgoing || (m.Message.Type != godbus.TypeMethodReply && m.Message.Type != godbus.TypeError) {
+		return false
+	}
+
+	s, ok := replySerial(m.Message)
+	return ok && s == serial
+}
+
+func sameCall(a, b *godbus.Message) bool {
+	for _, f := range []godbus.HeaderField{godbus.FieldDestination, godbus.FieldPath, godbus.FieldInterface, godbus.FieldMember} {
+		if header(a, f) != header(b, f) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// replayer plays the peer of a recorded connection, answering each call the
+// client makes with the reply to the matching recorded call.
+type replayer struct {
+	rec  *Recording
+	used []bool
+	sent []bool
+	rw   io.ReadWriter
+
+	// serial numbers the errors for calls not in the recording, clear of
+	// the recorded serials
+	serial uint32
+}
+
+// match returns the index of the first unanswered recorded call the same
+// as msg, preferring one with the same arguments.
+func (r *replayer) match(msg *godbus.Message) int {
+	found := -1
+
+	for i, m := range r.rec.Messages {
+		if r.used[i] || !isCall(m) || !sameCall(m.Message, msg) {
+			continue
+		}
+		if reflect.DeepEqual(m.Message.Body, msg.Body) {
+			return i
+		}
+		if found == -1 {
+			found = i
+		}
+	}
+
+	return found
+}
+
+func (r *replayer) send(i int, serial uint32) error {
+	msg := r.rec.Messages[i].Message
+	r.sent[i] = true
+
+	if serial != 0 {
+		// The reply goes back to the call as the client numbered it
+		reply := *msg
+		reply.Headers = make(map[godbus.HeaderField]godbus.Variant, len(msg.Headers))
+		for f, v := range msg.Headers {
+			reply.Headers[f] = v
+		}
+		reply.Headers[godbus.FieldReplySerial] = godbus.MakeVariant(serial)
+		msg = &reply
+	}
+
+	return msg.EncodeTo(r.rw, binary.LittleEndian)
+}
+
+// unsolicited sends the incoming messages from start up to the next
+// recorded call that are not replies, such as signals
+func (r *replayer) unsolicited(start int, call uint32, serial uint32) (bool, error) {
+	replied := false
+
+	for j := start; j < len(r.rec.Messages) && !isCall(r.rec.Messages[j]); j++ {
+		m := r.rec.Messages[j]
+		if m.Outgoing || r.sent[j] {
+			continue
+		}
+
+		switch {
+		case isReplyTo(m, call) && !replied:
+			replied = true
+			if err := r.send(j, serial); err != nil {
+				return replied, err
+			}
+		case m.Message.Type != godbus.TypeMethodReply && m.Message.Type != godbus.TypeError:
+			if err := r.send(j, 0); err != nil {
+				return replied, err
+			}
+		}
+	}
+
+	return replied, nil
+}
+
+func (r *replayer) unknown(msg *godbus.Message) error {
+	reply := &godbus.Message{
+		Type: godbus.TypeError,
+		Headers: map[godbus.HeaderField]godbus.Variant{
+			godbus.FieldErrorName:   godbus.MakeVariant("org.freedesktop.DBus.Error.UnknownMethod"),
+			godbus.FieldReplySerial: godbus.MakeVariant(msg.Serial()),
+		},
+		Body: []interface{}{fmt.Sprintf("no recorded call to %s on %s", methodName(msg), header(msg, godbus.FieldPath))},
+	}
+	reply.Headers[godbus.FieldSignature] = godbus.MakeVariant(godbus.SignatureOf(reply.Body...))
+	if sender := header(msg, godbus.FieldSender); sender != "" {
+		reply.Headers[godbus.FieldDestination] = godbus.MakeVariant(sender)
+	}
+
+	r.serial++
+	b, err := EncodeWithSerial(reply, r.serial)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.rw.Write(b)
+	return err
+}
+
+func (r *replayer) serve() error {
+	br := bufio.NewReader(r.rw)
+
+	if _, err := r.unsolicited(0, 0, 0); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := godbus.DecodeMessage(br)
+		if err != nil {
+			return err
+		}
+		if !expectsReply(msg) {
+			continue
+		}
+
+		i := r.match(msg)
+		if i == -1 {
+			if err := r.unknown(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		r.used[i] = true
+
+		call := r.rec.Messages[i].Message.Serial()
+		replied, err := r.unsolicited(i+1, call, msg.Serial())
+		if err != nil {
+			return err
+		}
+		if replied {
+			continue
+		}
+
+		// The reply arrived after later calls, as when calls are concurrent
+		for j := i + 1; j < len(r.rec.Messages); j++ {
+			if !r.sent[j] && isReplyTo(r.rec.Messages[j], call) {
+				if err := r.send(j, msg.Serial()); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+}
+
+// Replay returns a connection to an in-process peer that plays the other
+// side of rec: each method call is answered with the reply to the first
+// matching recorded call, with the same destination, path and member and
+// preferably the same arguments, followed by the signals recorded after it.
+// Calls not in the recording fail with UnknownMethod. If the recorded
+// connection said Hello so does this one, taking the recorded unique name.
+func Replay(rec *Recording, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	r := &replayer{
+		rec:    rec,
+		used:   make([]bool, len(rec.Messages)),
+		sent:   make([]bool, len(rec.Messages)),
+		serial: 1 << 31,
+	}
+	conn, err := pipeConn(func(c net.Conn) {
+		defer c.Close()
+		r.rw = c
+		if _, err := ServerAuth(c); err != nil {
+			return
+		}
+		r.serve()
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range rec.Messages {
+		if !isCall(m) {
+			continue
+		}
+		if methodName(m.Message) == "org.freedesktop.DBus.Hello" {
+			if err := conn.Hello(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		break
+	}
+
+	return conn, nil
+}
+
+// ReplayFile is Replay of the recording in the named file.
+func ReplayFile(path string, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	rec, err := LoadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Replay(rec, opts...)
+}
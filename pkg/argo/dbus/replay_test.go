@@ -0,0 +1,94 @@
+package dbus
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// notifier emits a signal on the connection it serves before replying
+type notifier struct {
+	conn *godbus.Conn
+}
+
+func (n notifier) Notify(s string) (string, *godbus.Error) {
+	n.conn.Emit("/test", "org.openxt.Test.Notified", s)
+	return "notified " + s, nil
+}
+
+// session runs the calls recorded and replayed by TestReplay
+func session(t *testing.T, conn *godbus.Conn) {
+	got := make(chan string, 1)
+	m := SignalMatch{Interface: "org.openxt.Test", Member: "Notified"}
+	sub, err := SubscribeFunc(context.Background(), conn, m, func(s string) {
+		got <- s
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	var out string
+	if err := conn.Object("", "/test").Call("org.openxt.Test.Notify", 0, "x").Store(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out != "notified x" {
+		t.Errorf("Notify = %q", out)
+	}
+
+	select {
+	case s := <-got:
+		if s != "x" {
+			t.Errorf("Notified %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("signal not delivered")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var b bytes.Buffer
+	rec, err := NewRecorder(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := peerPair(t, WithRecorder(rec))
+	if err := server.Export(notifier{server}, "/test", "org.openxt.Test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Hello(); err != nil {
+		t.Fatal(err)
+	}
+	session(t, client)
+	client.Close()
+	server.Close()
+
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	recording, err := ReadRecording(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := Replay(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if names := conn.Names(); len(names) == 0 || names[0] != ":1.42" {
+		t.Errorf("replayed names %q", names)
+	}
+	session(t, conn)
+
+	err = conn.Object("", "/test").Call("org.openxt.Test.Notify", 0, "x").Err
+	if e, ok := err.(godbus.Error); !ok || e.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Errorf("unrecorded call returned %v", err)
+	}
+}
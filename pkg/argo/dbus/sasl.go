@@ -0,0 +1,329 @@
+package dbus
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+const maxAuthLine = 16384
+
+var ErrAuthRejected = errors.New("dbus: client did not authenticate")
+
+func newGUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// readAuthLine reads a single line a byte at a time so that nothing past
+// BEGIN, which is D-Bus message data, is consumed.
+func readAuthLine(r io.Reader) ([][]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+		if len(line) > maxAuthLine {
+			return nil, errors.New("dbus: authentication line too long")
+		}
+	}
+
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+
+	return bytes.Split(line, []byte{' '}), nil
+}
+
+func writeAuthLine(w io.Writer, line string) error {
+	_, err := io.WriteString(w, line+"\r\n")
+	return err
+}
+
+// ServerAuth runs the server side of the SASL exchange on rw, returning the
+// server guid once the client sends BEGIN, after which rw carries D-Bus
+// messages. See saslServer for the mechanisms accepted.
+//
+// An EXTERNAL identity is taken as the client claims it and never checked,
+// since argo passes no credentials to verify it against. Callers must
+// decide whether to admit a peer from its source domain, as Server.Allow
+// does, not from the fact that it authenticated.
+func ServerAuth(rw io.ReadWriter) (string, error) {
+	guid, err := newGUID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := serverAuth(rw, guid); err != nil {
+		return "", err
+	}
+
+	return guid, nil
+}
+
+// serverAuth reads the client side of the SASL exchange from rw and answers
+// it, returning once the client sends BEGIN.
+func serverAuth(rw io.ReadWriter, guid string) error {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(rw, b); err != nil {
+		return err
+	}
+	if b[0] != 0 {
+		return errNoNullByte
+	}
+
+	sasl := saslServer{guid: guid}
+	for {
+		s, err := readAuthLine(rw)
+		if err != nil {
+			if err == io.EOF {
+				return ErrAuthRejected
+			}
+			return err
+		}
+
+		reply, begin := sasl.handle(s)
+		if begin {
+			return nil
+		}
+		if err := writeAuthLine(rw, reply); err != nil {
+			return err
+		}
+	}
+}
+
+var errNoNullByte = errors.New("dbus: authentication protocol error (no null byte)")
+
+// saslServer is the server state of a SASL exchange, accepting the EXTERNAL
+// and ANONYMOUS mechanisms. Over argo the kernel passes no credentials, the
+// identity of a peer is its source domain which is checked by the server
+// policy, so EXTERNAL data only has to be well formed hex.
+type saslServer struct {
+	guid          string
+	authenticated bool
+	waitingData   bool
+}
+
+// handle answers a single client line, reporting begin instead of a reply
+// once an authenticated client sends BEGIN.
+func (a *saslServer) handle(s [][]byte) (reply string, begin bool) {
+	const mechs = "REJECTED EXTERNAL ANONYMOUS"
+
+	switch cmd := string(s[0]); {
+	case cmd == "AUTH" && !a.authenticated:
+		switch {
+		case len(s) < 2:
+			reply = mechs
+		case string(s[1]) == "ANONYMOUS":
+			a.authenticated = true
+		case string(s[1]) == "EXTERNAL" && len(s) == 2:
+			a.waitingData = true
+			reply = "DATA"
+		case string(s[1]) == "EXTERNAL" && len(s) == 3:
+			if _, err := hex.DecodeString(string(s[2])); err != nil {
+				reply = mechs
+			} else {
+				a.authenticated = true
+			}
+		default:
+			reply = mechs
+		}
+		if a.authenticated {
+			reply = "OK " + a.guid
+		}
+	case cmd == "DATA" && a.waitingData:
+		a.waitingData = false
+		if len(s) > 2 {
+			return mechs, false
+		}
+		if len(s) == 2 {
+			if _, err := hex.DecodeString(string(s[1])); err != nil {
+				return mechs, false
+			}
+		}
+		a.authenticated = true
+		reply = "OK " + a.guid
+	case cmd == "CANCEL" || cmd == "ERROR":
+		a.authenticated = false
+		a.waitingData = false
+		reply = mechs
+	case cmd == "NEGOTIATE_UNIX_FD":
+		reply = "ERROR unix fd passing not supported"
+	case cmd == "BEGIN" && a.authenticated:
+		return "", true
+	default:
+		reply = fmt.Sprintf("ERROR unexpected %s", cmd)
+	}
+
+	return reply, false
+}
+
+// ClientAuth runs the client side of the SASL exchange on rw, trying each
+// mechanism in order, or DefaultAuth if methods is nil, and sending BEGIN
+// once one is accepted, after which rw carries D-Bus messages. It is for
+// relays forwarding messages unchanged, other clients authenticate with
+// godbus.Conn.Auth.
+func ClientAuth(rw io.ReadWriter, methods []godbus.Auth) error {
+	if methods == nil {
+		methods = DefaultAuth()
+	}
+
+	if _, err := rw.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	for _, m := range methods {
+		ok, err := clientAuth(rw, m)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return writeAuthLine(rw, "BEGIN")
+		}
+	}
+
+	return errors.New("dbus: authentication failed")
+}
+
+// clientAuth tries a single mechanism, returning whether the server
+// accepted it. Mechanism data is exchanged hex encoded as godbus does.
+func clientAuth(rw io.ReadWriter, m godbus.Auth) (bool, error) {
+	name, data, _ := m.FirstData()
+	line := "AUTH " + string(name)
+	if len(data) > 0 {
+		line += " " + string(data)
+	}
+	if err := writeAuthLine(rw, line); err != nil {
+		return false, err
+	}
+
+	for {
+		s, err := readAuthLine(rw)
+		if err != nil {
+			return false, err
+		}
+
+		switch string(s[0]) {
+		case "OK":
+			return true, nil
+		case "REJECTED":
+			return false, nil
+		case "DATA":
+			var challenge []byte
+			if len(s) > 1 {
+				challenge = s[1]
+			}
+			resp, status := m.HandleData(challenge)
+			if status == godbus.AuthError {
+				line = "CANCEL"
+			} else if len(resp) > 0 {
+				line = "DATA " + string(resp)
+			} else {
+				line = "DATA"
+			}
+		default:
+			line = "CANCEL"
+		}
+
+		if err := writeAuthLine(rw, line); err != nil {
+			return false, err
+		}
+	}
+}
+
+// handshakeShim stands between a godbus client connection and a transport
+// on which serverAuth has already run. godbus only starts reading messages
+// once its client Auth completes, so the shim answers that exchange locally
+// with its own saslServer, line by line as godbus writes them, and passes
+// traffic through from BEGIN on.
+type handshakeShim struct {
+	io.ReadWriteCloser
+	sasl    saslServer
+	nul     bool
+	pending []byte
+	reply   []byte
+	begun   bool
+}
+
+func newHandshakeShim(rwc io.ReadWriteCloser, guid string) *handshakeShim {
+	return &handshakeShim{
+		ReadWriteCloser: rwc,
+		sasl:            saslServer{guid: guid},
+	}
+}
+
+func (s *handshakeShim) Read(p []byte) (int, error) {
+	if len(s.reply) > 0 {
+		n := copy(p, s.reply)
+		s.reply = s.reply[n:]
+		return n, nil
+	}
+	if !s.begun {
+		return 0, errors.New("dbus: read with no authentication reply pending")
+	}
+
+	return s.ReadWriteCloser.Read(p)
+}
+
+func (s *handshakeShim) Write(p []byte) (int, error) {
+	if s.begun {
+		return s.ReadWriteCloser.Write(p)
+	}
+
+	n := len(p)
+	if !s.nul && n > 0 {
+		if p[0] != 0 {
+			return 0, errNoNullByte
+		}
+		s.nul = true
+		p = p[1:]
+	}
+
+	s.pending = append(s.pending, p...)
+	for !s.begun {
+		i := bytes.IndexByte(s.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(s.pending[:i], []byte{'\r'})
+		s.pending = s.pending[i+1:]
+
+		reply, begin := s.sasl.handle(bytes.Split(line, []byte{' '}))
+		if begin {
+			s.begun = true
+			break
+		}
+		s.reply = append(s.reply, reply+"\r\n"...)
+	}
+
+	if !s.begun {
+		if len(s.pending) > maxAuthLine {
+			return 0, errors.New("dbus: authentication line too long")
+		}
+		return n, nil
+	}
+
+	// Anything written after BEGIN is message data
+	rest := s.pending
+	s.pending = nil
+	if len(rest) > 0 {
+		if _, err := s.ReadWriteCloser.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
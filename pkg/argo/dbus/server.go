@@ -0,0 +1,224 @@
+package dbus
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+var ErrServerClosed = errors.New("dbus: server closed")
+
+// NewServerConn runs the server side of the D-Bus handshake on c and
+// returns a connection to the single peer on the other end, on which
+// objects can be exported. No bus is involved, so Hello is never sent and
+// messages need no destination.
+func NewServerConn(c net.Conn, opts ...godbus.ConnOption) (*godbus.Conn, error) {
+	guid, err := ServerAuth(c)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := godbus.NewConn(newHandshakeShim(c, guid), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Auth([]godbus.Auth{godbus.AuthAnonymous()}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// AllowDomains returns a Server policy admitting only the given domains.
+func AllowDomains(domains ...argo.DomainId) func(peer argo.Addr) bool {
+	return func(peer argo.Addr) bool {
+		for _, d := range domains {
+			if peer.Domain == d {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Server serves D-Bus objects directly to peer domains over argo, without
+// a bus daemon.
+type Server struct {
+	// Allow decides from the source address whether a peer may connect,
+	// nil admits every peer
+	Allow func(peer argo.Addr) bool
+
+	// Setup is called with each authenticated peer connection to export
+	// its objects, returning an error drops the peer
+	Setup func(conn *godbus.Conn, peer argo.Addr) error
+
+	// Options applied to every peer connection
+	Options []godbus.ConnOption
+
+	// HandshakeTimeout bounds the SASL exchange of a new peer, zero means
+	// no limit. It is enforced with a deadline on the connection.
+	HandshakeTimeout time.Duration
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[*godbus.Conn]struct{}
+}
+
+// ListenAndServe listens on the argo port for connections from partner,
+// which may be XEN_ARGO_DOMID_ANY, and serves them.
+func (s *Server) ListenAndServe(port int, partner argo.DomainId) error {
+	l, err := argo.Listen(syscall.SOCK_STREAM, port, partner)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts peers on l until the server is closed, always returning a
+// non-nil error.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	peer, _ := c.RemoteAddr().(argo.Addr)
+
+	if s.Allow != nil && !s.Allow(peer) {
+		log.Printf("dbus: rejected peer %v", peer)
+		c.Close()
+		return
+	}
+
+	// The deadline interrupts a stalled handshake on argo connections,
+	// where close does not. Transports without deadlines fall back to
+	// closing, which cannot interrupt a descriptor outside the runtime
+	// poller.
+	var timer *time.Timer
+	if s.HandshakeTimeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(s.HandshakeTimeout)); err != nil {
+			timer = time.AfterFunc(s.HandshakeTimeout, func() { c.Close() })
+		}
+	}
+
+	conn, err := NewServerConn(c, s.Options...)
+	if timer != nil && !timer.Stop() {
+		err = errors.New("handshake timed out")
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("dbus: handshake with %v failed: %v", peer, err)
+		c.Close()
+		return
+	}
+	if s.HandshakeTimeout > 0 && timer == nil {
+		c.SetDeadline(time.Time{})
+	}
+
+	if !s.track(conn) {
+		conn.Close()
+		return
+	}
+	defer s.untrack(conn)
+
+	if s.Setup != nil {
+		if err := s.Setup(conn, peer); err != nil {
+			log.Printf("dbus: setup for %v failed: %v", peer, err)
+			conn.Close()
+			return
+		}
+	}
+
+	<-conn.Context().Done()
+}
+
+func (s *Server) track(conn *godbus.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*godbus.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (s *Server) untrack(conn *godbus.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+// Close stops all listeners and closes every peer connection. An argo
+// Accept is only unblocked while the listener descriptor is in the runtime
+// poller, see argo.Conn.Fd.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	return err
+}
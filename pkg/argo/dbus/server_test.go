@@ -0,0 +1,118 @@
+package dbus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/openxt/openxt-go/pkg/argo"
+)
+
+type echo struct{}
+
+func (echo) Echo(s string) (string, *godbus.Error) {
+	return s, nil
+}
+
+func TestServerConn(t *testing.T) {
+	pl := argo.NewPipeListener(argo.Addr{Domain: 0, Port: 5556})
+
+	s := &Server{
+		Allow: AllowDomains(3),
+		Setup: func(conn *godbus.Conn, peer argo.Addr) error {
+			return conn.Export(echo{}, "/echo", "org.openxt.Echo")
+		},
+	}
+	defer s.Close()
+	go s.Serve(pl)
+
+	c, err := pl.Dial(argo.Addr{Domain: 3, Port: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := godbus.NewConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Auth(DefaultAuth()); err != nil {
+		t.Fatalf("Auth error: %v", err)
+	}
+
+	var reply string
+	err = conn.Object("", "/echo").Call("org.openxt.Echo.Echo", 0, "hello").Store(&reply)
+	if err != nil {
+		t.Fatalf("Call error: %v", err)
+	}
+	if reply != "hello" {
+		t.Errorf("reply %q, want %q", reply, "hello")
+	}
+}
+
+func TestServerPolicy(t *testing.T) {
+	pl := argo.NewPipeListener(argo.Addr{Domain: 0, Port: 5556})
+
+	s := &Server{Allow: AllowDomains(3)}
+	defer s.Close()
+	go s.Serve(pl)
+
+	c, err := pl.Dial(argo.Addr{Domain: 4, Port: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := godbus.NewConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Auth(AnonymousAuth()); err == nil {
+		t.Errorf("Auth succeeded for a domain outside the policy")
+	}
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	pl := argo.NewPipeListener(argo.Addr{Domain: 0, Port: 5556})
+
+	s := &Server{HandshakeTimeout: 50 * time.Millisecond}
+	defer s.Close()
+	go s.Serve(pl)
+
+	// A peer that never starts SASL is dropped once the timeout passes
+	c, err := pl.Dial(argo.Addr{Domain: 3, Port: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read from stalled handshake succeeded")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("stalled handshake was not dropped")
+	}
+
+	// A peer completing the handshake in time keeps its connection past it
+	c, err = pl.Dial(argo.Addr{Domain: 3, Port: 1001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := godbus.NewConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Auth(AnonymousAuth()); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	err = conn.Object("", "/missing").Call("org.openxt.Echo.Echo", 0, "hi").Err
+	if _, ok := err.(godbus.Error); !ok {
+		t.Errorf("call after handshake failed with %v, want a D-Bus error reply", err)
+	}
+}
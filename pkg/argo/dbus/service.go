@@ -0,0 +1,376 @@
+package dbus
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// Interface declares one interface of an object exported by a Service.
+type Interface struct {
+	Name string
+
+	// Methods maps member names to functions, as with
+	// godbus.Conn.ExportMethodTable. The last result of each function
+	// must be a *godbus.Error.
+	Methods map[string]interface{}
+
+	// Properties served through org.freedesktop.DBus.Properties, with
+	// PropertiesChanged emitted as set by each Prop's Emit
+	Properties map[string]*prop.Prop
+
+	// Signals the interface emits, for introspection only
+	Signals []introspect.Signal
+}
+
+// MethodsOf returns the method table of the exported methods of v whose
+// last result is a *godbus.Error, keyed by their Go names.
+func MethodsOf(v interface{}) map[string]interface{} {
+	methods := make(map[string]interface{})
+
+	rv := reflect.ValueOf(v)
+	rt := rv.Type()
+	for i := 0; i < rt.NumMethod(); i++ {
+		m := rt.Method(i)
+		if m.PkgPath != "" {
+			continue
+		}
+		mt := m.Type
+		if mt.NumOut() == 0 || mt.Out(mt.NumOut()-1) != reflect.TypeOf((*godbus.Error)(nil)) {
+			continue
+		}
+		methods[m.Name] = rv.Method(i).Interface()
+	}
+
+	return methods
+}
+
+var (
+	senderType  = reflect.TypeOf(godbus.Sender(""))
+	messageType = reflect.TypeOf(godbus.Message{})
+)
+
+func introspectMethods(methods map[string]interface{}) []introspect.Method {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []introspect.Method
+	for _, name := range names {
+		ft := reflect.TypeOf(methods[name])
+		if ft.Kind() != reflect.Func {
+			continue
+		}
+
+		m := introspect.Method{Name: name}
+		for i := 0; i < ft.NumIn(); i++ {
+			if ft.In(i) == senderType || ft.In(i) == messageType {
+				continue
+			}
+			m.Args = append(m.Args, introspect.Arg{Type: godbus.SignatureOfType(ft.In(i)).String(), Direction: "in"})
+		}
+		for i := 0; i < ft.NumOut()-1; i++ {
+			m.Args = append(m.Args, introspect.Arg{Type: godbus.SignatureOfType(ft.Out(i)).String(), Direction: "out"})
+		}
+		out = append(out, m)
+	}
+
+	return out
+}
+
+type serviceObject struct {
+	ifaces []Interface
+	props  *prop.Properties
+}
+
+// Service owns a well-known name and serves objects on a bus connection,
+// generating their introspection data and Properties implementation. The
+// connection may be to the system bus or to the platform bus over argo.
+type Service struct {
+	conn *godbus.Conn
+	name string
+
+	mu           sync.Mutex
+	objects      map[godbus.ObjectPath]*serviceObject
+	introspected map[godbus.ObjectPath]bool
+}
+
+func NewService(conn *godbus.Conn, name string) *Service {
+	return &Service{
+		conn:         conn,
+		name:         name,
+		objects:      make(map[godbus.ObjectPath]*serviceObject),
+		introspected: make(map[godbus.ObjectPath]bool),
+	}
+}
+
+// Conn returns the connection the service is served on.
+func (s *Service) Conn() *godbus.Conn {
+	return s.conn
+}
+
+// RequestName takes ownership of the service name, failing unless the
+// service becomes its primary owner.
+func (s *Service) RequestName(flags godbus.RequestNameFlags) error {
+	reply, err := s.conn.RequestName(s.name, flags|godbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+
+	switch reply {
+	case godbus.RequestNameReplyPrimaryOwner, godbus.RequestNameReplyAlreadyOwner:
+		return nil
+	default:
+		return fmt.Errorf("dbus: name %s is owned by another connection", s.name)
+	}
+}
+
+// Export serves an object at path with the given interfaces, replacing any
+// object already exported there.
+func (s *Service) Export(path godbus.ObjectPath, ifaces ...Interface) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.objects[path]; ok {
+		s.unexport(path, old)
+	}
+
+	o := &serviceObject{ifaces: ifaces}
+	props := make(map[string]map[string]*prop.Prop)
+
+	for _, iface := range ifaces {
+		if len(iface.Methods) > 0 {
+			if err := s.conn.ExportMethodTable(iface.Methods, path, iface.Name); err != nil {
+				s.unexport(path, o)
+				return err
+			}
+		}
+		if len(iface.Properties) > 0 {
+			props[iface.Name] = iface.Properties
+		}
+	}
+
+	if len(props) > 0 {
+		p, err := prop.Export(s.conn, path, props)
+		if err != nil {
+			s.unexport(path, o)
+			return err
+		}
+		o.props = p
+	}
+
+	s.objects[path] = o
+
+	return s.updateIntrospection()
+}
+
+// Unexport stops serving the object at path.
+func (s *Service) Unexport(path godbus.ObjectPath) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.objects[path]
+	if !ok {
+		return fmt.Errorf("dbus: no object exported at %s", path)
+	}
+	s.unexport(path, o)
+	delete(s.objects, path)
+
+	return s.updateIntrospection()
+}
+
+func (s *Service) unexport(path godbus.ObjectPath, o *serviceObject) {
+	for _, iface := range o.ifaces {
+		s.conn.Export(nil, path, iface.Name)
+	}
+	if o.props != nil {
+		s.conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	}
+}
+
+// parents returns the ancestors of path, from the root down.
+func parents(path godbus.ObjectPath) []godbus.ObjectPath {
+	var out []godbus.ObjectPath
+
+	p := string(path)
+	for p != "/" {
+		i := strings.LastIndex(p, "/")
+		if i == 0 {
+			p = "/"
+		} else {
+			p = p[:i]
+		}
+		out = append([]godbus.ObjectPath{godbus.ObjectPath(p)}, out...)
+	}
+
+	return out
+}
+
+// updateIntrospection exports introspection data for every object and
+// each of their ancestors, so the tree can be walked from the root.
+func (s *Service) updateIntrospection() error {
+	nodes := make(map[godbus.ObjectPath]*introspect.Node)
+	children := make(map[godbus.ObjectPath]map[string]bool)
+
+	node := func(p godbus.ObjectPath) *introspect.Node {
+		n, ok := nodes[p]
+		if !ok {
+			n = &introspect.Node{
+				Name:       string(p),
+				Interfaces: []introspect.Interface{introspect.IntrospectData},
+			}
+			nodes[p] = n
+			children[p] = make(map[string]bool)
+		}
+		return n
+	}
+
+	for path, o := range s.objects {
+		n := node(path)
+
+		if o.props != nil {
+			n.Interfaces = append(n.Interfaces, prop.IntrospectData)
+		}
+		for _, iface := range o.ifaces {
+			i := introspect.Interface{
+				Name:    iface.Name,
+				Methods: introspectMethods(iface.Methods),
+				Signals: iface.Signals,
+			}
+			if o.props != nil && len(iface.Properties) > 0 {
+				i.Properties = o.props.Introspection(iface.Name)
+				sort.Slice(i.Properties, func(a, b int) bool { return i.Properties[a].Name < i.Properties[b].Name })
+			}
+			n.Interfaces = append(n.Interfaces, i)
+		}
+
+		child := path
+		for _, p := range reverse(parents(path)) {
+			node(p)
+			children[p][string(child)[strings.LastIndex(string(child), "/")+1:]] = true
+			child = p
+		}
+	}
+
+	for p, n := range nodes {
+		names := make([]string, 0, len(children[p]))
+		for c := range children[p] {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+		for _, c := range names {
+			n.Children = append(n.Children, introspect.Node{Name: c})
+		}
+
+		if err := s.conn.Export(introspect.NewIntrospectable(n), p, "org.freedesktop.DBus.Introspectable"); err != nil {
+			return err
+		}
+	}
+
+	for p := range s.introspected {
+		if _, ok := nodes[p]; !ok {
+			s.conn.Export(nil, p, "org.freedesktop.DBus.Introspectable")
+		}
+	}
+	s.introspected = make(map[godbus.ObjectPath]bool, len(nodes))
+	for p := range nodes {
+		s.introspected[p] = true
+	}
+
+	return nil
+}
+
+func reverse(paths []godbus.ObjectPath) []godbus.ObjectPath {
+	for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
+		paths[i], paths[j] = paths[j], paths[i]
+	}
+	return paths
+}
+
+// SetProperty changes a property from the service side, emitting
+// PropertiesChanged as configured for it. The value is stored even when
+// the signal cannot be sent, in which case the error is returned.
+func (s *Service) SetProperty(path godbus.ObjectPath, iface, name string, value interface{}) error {
+	s.mu.Lock()
+	o, ok := s.objects[path]
+	s.mu.Unlock()
+
+	if !ok || o.props == nil {
+		return fmt.Errorf("dbus: no properties exported at %s", path)
+	}
+	for _, i := range o.ifaces {
+		if _, ok := i.Properties[name]; ok && i.Name == iface {
+			return setProperty(o.props, iface, name, value)
+		}
+	}
+
+	return fmt.Errorf("dbus: no property %s.%s at %s", iface, name, path)
+}
+
+// setProperty is prop.Properties.SetMust returning the error it panics with
+// when PropertiesChanged fails to send, e.g. on a closed connection.
+func setProperty(p *prop.Properties, iface, name string, value interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	p.SetMust(iface, name, value)
+
+	return nil
+}
+
+// GetProperty returns the current value of a property.
+func (s *Service) GetProperty(path godbus.ObjectPath, iface, name string) (interface{}, error) {
+	s.mu.Lock()
+	o, ok := s.objects[path]
+	s.mu.Unlock()
+
+	if !ok || o.props == nil {
+		return nil, fmt.Errorf("dbus: no properties exported at %s", path)
+	}
+
+	v, err := o.props.Get(iface, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Value(), nil
+}
+
+// Emit sends a signal from the object at path.
+func (s *Service) Emit(path godbus.ObjectPath, iface, member string, args ...interface{}) error {
+	return s.conn.Emit(path, iface+"."+member, args...)
+}
+
+// Close unexports every object and releases the service name.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	for path, o := range s.objects {
+		s.unexport(path, o)
+	}
+	s.objects = make(map[godbus.ObjectPath]*serviceObject)
+	ierr := s.updateIntrospection()
+	s.mu.Unlock()
+
+	// The name is released even if the introspection data was not
+	_, err := s.conn.ReleaseName(s.name)
+	if ierr != nil {
+		return ierr
+	}
+
+	return err
+}
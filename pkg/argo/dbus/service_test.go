@@ -0,0 +1,123 @@
+package dbus
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+func TestService(t *testing.T) {
+	client, server := peerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	s := NewService(server, "org.openxt.Test")
+	err := s.Export("/vm/1", Interface{
+		Name:    "org.openxt.Test",
+		Methods: MethodsOf(echo{}),
+		Properties: map[string]*prop.Prop{
+			"Name":  {Value: "guest", Writable: true, Emit: prop.EmitTrue},
+			"Domid": {Value: int32(1), Emit: prop.EmitFalse},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj := client.Object("", "/vm/1")
+
+	var out string
+	if err := obj.Call("org.openxt.Test.Echo", 0, "hi").Store(&out); err != nil || out != "hi" {
+		t.Fatalf("Echo = %q, %v", out, err)
+	}
+
+	var xml string
+	if err := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<method name="Echo">`, `<property name="Name" type="s" access="readwrite">`, `<interface name="org.freedesktop.DBus.Properties">`} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("introspection missing %s:\n%s", want, xml)
+		}
+	}
+
+	if err := client.Object("", "/").Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xml, `<node name="vm">`) {
+		t.Errorf("root introspection missing child:\n%s", xml)
+	}
+
+	changed := make(chan map[string]godbus.Variant, 1)
+	m := SignalMatch{Interface: "org.freedesktop.DBus.Properties", Member: "PropertiesChanged", Path: "/vm/1"}
+	sub, err := SubscribeFunc(context.Background(), client, m, func(iface string, props map[string]godbus.Variant, invalidated []string) {
+		changed <- props
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if err := obj.SetProperty("org.openxt.Test.Name", godbus.MakeVariant("renamed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.SetProperty("org.openxt.Test.Domid", godbus.MakeVariant(int32(2))); err == nil {
+		t.Error("set of read-only property succeeded")
+	}
+
+	select {
+	case props := <-changed:
+		if v := props["Name"].Value(); v != "renamed" {
+			t.Errorf("PropertiesChanged Name = %v", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("PropertiesChanged not delivered")
+	}
+
+	if err := s.SetProperty("/vm/1", "org.openxt.Test", "Domid", int32(3)); err != nil {
+		t.Fatal(err)
+	}
+	v, err := obj.GetProperty("org.openxt.Test.Domid")
+	if err != nil || v.Value() != int32(3) {
+		t.Errorf("Domid = %v, %v", v, err)
+	}
+	if err := s.SetProperty("/vm/1", "org.openxt.Test", "Missing", 0); err == nil {
+		t.Error("set of missing property succeeded")
+	}
+
+	if err := s.Unexport("/vm/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Call("org.openxt.Test.Echo", 0, "hi").Err; err == nil {
+		t.Error("call to unexported object succeeded")
+	}
+}
+
+func TestServiceClosedConn(t *testing.T) {
+	client, server := peerPair(t)
+	defer client.Close()
+
+	s := NewService(server, "org.openxt.Test")
+	err := s.Export("/vm/1", Interface{
+		Name: "org.openxt.Test",
+		Properties: map[string]*prop.Prop{
+			"Name": {Value: "guest", Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PropertiesChanged cannot be sent, which is an error and not a panic
+	server.Close()
+	if err := s.SetProperty("/vm/1", "org.openxt.Test", "Name", "renamed"); err != godbus.ErrClosed {
+		t.Errorf("SetProperty on a closed connection = %v, want %v", err, godbus.ErrClosed)
+	}
+	if v, err := s.GetProperty("/vm/1", "org.openxt.Test", "Name"); err != nil || v != "renamed" {
+		t.Errorf("Name = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,249 @@
+package dbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// SignalConn is the part of a connection subscriptions use. It is
+// implemented by *godbus.Conn and by *ManagedConn, through which
+// subscriptions survive reconnects.
+type SignalConn interface {
+	AddMatchSignal(options ...godbus.MatchOption) error
+	RemoveMatchSignal(options ...godbus.MatchOption) error
+	Signal(ch chan<- *godbus.Signal)
+	RemoveSignal(ch chan<- *godbus.Signal)
+}
+
+var (
+	_ SignalConn = (*godbus.Conn)(nil)
+	_ SignalConn = (*ManagedConn)(nil)
+)
+
+// SignalMatch selects the signals of a subscription, empty fields match
+// anything.
+type SignalMatch struct {
+	Sender    string
+	Interface string
+	Member    string
+	Path      godbus.ObjectPath
+}
+
+func (m SignalMatch) options() []godbus.MatchOption {
+	var opts []godbus.MatchOption
+
+	if m.Sender != "" {
+		opts = append(opts, godbus.WithMatchSender(m.Sender))
+	}
+	if m.Interface != "" {
+		opts = append(opts, godbus.WithMatchInterface(m.Interface))
+	}
+	if m.Member != "" {
+		opts = append(opts, godbus.WithMatchMember(m.Member))
+	}
+	if m.Path != "" {
+		opts = append(opts, godbus.WithMatchObjectPath(m.Path))
+	}
+
+	return opts
+}
+
+// matches filters the signals delivered to every channel of the connection.
+// Senders are not compared as the bus reports unique names.
+func (m SignalMatch) matches(sig *godbus.Signal) bool {
+	i := strings.LastIndex(sig.Name, ".")
+	if i == -1 {
+		return false
+	}
+
+	return (m.Interface == "" || m.Interface == sig.Name[:i]) &&
+		(m.Member == "" || m.Member == sig.Name[i+1:]) &&
+		(m.Path == "" || m.Path == sig.Path)
+}
+
+// Subscription delivers the signals matching a SignalMatch until it is
+// closed or its context is done.
+type Subscription struct {
+	conn    SignalConn
+	opts    []godbus.MatchOption
+	sigs    chan *godbus.Signal
+	done    chan struct{}
+	once    sync.Once
+	err     error
+	deliver func(*godbus.Signal)
+}
+
+func newSubscription(conn SignalConn, m SignalMatch) *Subscription {
+	return &Subscription{
+		conn: conn,
+		opts: m.options(),
+		sigs: make(chan *godbus.Signal, 16),
+		done: make(chan struct{}),
+	}
+}
+
+func (s *Subscription) start(ctx context.Context, m SignalMatch) error {
+	if err := s.conn.AddMatchSignal(s.opts...); err != nil {
+		return err
+	}
+	s.conn.Signal(s.sigs)
+
+	go func() {
+		for {
+			select {
+			case sig, ok := <-s.sigs:
+				if !ok {
+					return
+				}
+				if m.matches(sig) {
+					s.deliver(sig)
+				}
+			case <-ctx.Done():
+				s.Close()
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Subscribe calls handler with every signal matching m.
+func Subscribe(ctx context.Context, conn SignalConn, m SignalMatch, handler func(*godbus.Signal)) (*Subscription, error) {
+	s := newSubscription(conn, m)
+	s.deliver = handler
+
+	if err := s.start(ctx, m); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SubscribeFunc calls fn with the decoded body of every signal matching m.
+// fn must be a function whose parameters are the types of the signal
+// arguments, e.g. func(uuid string, path godbus.ObjectPath, state string).
+// Signals whose body does not decode into the parameters are dropped.
+func SubscribeFunc(ctx context.Context, conn SignalConn, m SignalMatch, fn interface{}) (*Subscription, error) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.IsVariadic() {
+		return nil, errors.New("dbus: SubscribeFunc needs a non-variadic function")
+	}
+
+	return Subscribe(ctx, conn, m, func(sig *godbus.Signal) {
+		args := make([]reflect.Value, ft.NumIn())
+		dest := make([]interface{}, ft.NumIn())
+		for i := range args {
+			args[i] = reflect.New(ft.In(i))
+			dest[i] = args[i].Interface()
+		}
+		if err := godbus.Store(sig.Body, dest...); err != nil {
+			return
+		}
+		for i := range args {
+			args[i] = args[i].Elem()
+		}
+
+		fv.Call(args)
+	})
+}
+
+// SubscribeChan sends every signal matching m to ch, which is a channel of
+// *godbus.Signal, of a struct or of a pointer to a struct. Signal bodies are
+// decoded into the struct fields in order and those that do not decode are
+// dropped. Sends block, abandoned when the subscription is closed.
+func SubscribeChan(ctx context.Context, conn SignalConn, m SignalMatch, ch interface{}) (*Subscription, error) {
+	cv := reflect.ValueOf(ch)
+	ct := cv.Type()
+	if ct.Kind() != reflect.Chan || ct.ChanDir()&reflect.SendDir == 0 {
+		return nil, fmt.Errorf("dbus: SubscribeChan needs a channel to send on, got %v", ct)
+	}
+
+	elem := ct.Elem()
+	ptr := elem.Kind() == reflect.Ptr
+	st := elem
+	if ptr {
+		st = elem.Elem()
+	}
+	raw := elem == reflect.TypeOf((*godbus.Signal)(nil))
+	if !raw && st.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dbus: SubscribeChan needs a channel of structs, got %v", ct)
+	}
+
+	s := newSubscription(conn, m)
+	s.deliver = func(sig *godbus.Signal) {
+		var v reflect.Value
+		if raw {
+			v = reflect.ValueOf(sig)
+		} else {
+			p := reflect.New(st)
+			dest := make([]interface{}, st.NumField())
+			for i := range dest {
+				dest[i] = p.Elem().Field(i).Addr().Interface()
+			}
+			if err := godbus.Store(sig.Body, dest...); err != nil {
+				return
+			}
+			v = p
+			if !ptr {
+				v = p.Elem()
+			}
+		}
+
+		reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: cv, Send: v},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.done)},
+		})
+	}
+
+	if err := s.start(ctx, m); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// WatchSignal calls deliver with every signal matching m until the
+// returned function is called, which closes done so that deliver can
+// abandon a blocked send. It is the shape of the Watch methods of the
+// daemon clients, e.g.
+//
+//   return dbus.WatchSignal(c.conn, dbus.SignalMatch{Interface: Interface, Member: "changed"},
+//       func(sig *godbus.Signal, done <-chan struct{}) { ... })
+func WatchSignal(conn SignalConn, m SignalMatch, deliver func(sig *godbus.Signal, done <-chan struct{})) (func() error, error) {
+	s := newSubscription(conn, m)
+	s.deliver = func(sig *godbus.Signal) {
+		deliver(sig, s.done)
+	}
+
+	if err := s.start(context.Background(), m); err != nil {
+		return nil, err
+	}
+
+	return s.Close, nil
+}
+
+// Done is closed once the subscription is closed.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close stops delivery and removes the match rule.
+func (s *Subscription) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.conn.RemoveSignal(s.sigs)
+		s.err = s.conn.RemoveMatchSignal(s.opts...)
+	})
+
+	return s.err
+}
@@ -0,0 +1,132 @@
+package dbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// peerPair connects a client, created with opts, to a test peer, returning
+// the server side to emit signals on.
+func peerPair(t *testing.T, opts ...godbus.ConnOption) (client, server *godbus.Conn) {
+	client, server, err := NewTestPeer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, server
+}
+
+func TestSubscribeFunc(t *testing.T) {
+	client, server := peerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	type change struct {
+		name  string
+		value int32
+	}
+	got := make(chan change, 1)
+
+	m := SignalMatch{Interface: "org.openxt.Test", Member: "Changed", Path: "/test"}
+	sub, err := SubscribeFunc(context.Background(), client, m, func(name string, value int32) {
+		got <- change{name, value}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	server.Emit("/other", "org.openxt.Test.Changed", "ignored", int32(0))
+	server.Emit("/test", "org.openxt.Test.Changed", "x", int32(7))
+
+	select {
+	case c := <-got:
+		if c.name != "x" || c.value != 7 {
+			t.Errorf("got %+v", c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("signal not delivered")
+	}
+}
+
+func TestSubscribeChanContext(t *testing.T) {
+	client, server := peerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	type changed struct {
+		Name  string
+		Value int32
+	}
+	ch := make(chan *changed, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := SignalMatch{Interface: "org.openxt.Test", Member: "Changed"}
+	sub, err := SubscribeChan(ctx, client, m, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Emit("/test", "org.openxt.Test.Changed", "y", int32(3))
+	select {
+	case c := <-ch:
+		if c.Name != "y" || c.Value != 3 {
+			t.Errorf("got %+v", c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("signal not delivered")
+	}
+
+	cancel()
+	select {
+	case <-sub.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not closed by context")
+	}
+}
+
+func TestWatchSignal(t *testing.T) {
+	client, server := peerPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	ch := make(chan string)
+	m := SignalMatch{Interface: "org.openxt.Test", Member: "Changed"}
+	stop, err := WatchSignal(client, m, func(sig *godbus.Signal, done <-chan struct{}) {
+		var name string
+		if err := godbus.Store(sig.Body, &name); err != nil {
+			return
+		}
+		select {
+		case ch <- name:
+		case <-done:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Emit("/test", "org.openxt.Test.Other", "ignored")
+	server.Emit("/test", "org.openxt.Test.Changed", "z")
+	select {
+	case name := <-ch:
+		if name != "z" {
+			t.Errorf("got %q", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("signal not delivered")
+	}
+
+	// Stopping abandons a send nobody receives
+	server.Emit("/test", "org.openxt.Test.Changed", "unread")
+	time.Sleep(50 * time.Millisecond)
+	if err := stop(); err != nil {
+		t.Errorf("stop error: %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("second stop error: %v", err)
+	}
+}
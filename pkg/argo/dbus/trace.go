@@ -0,0 +1,298 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// latencyBounds are the upper bounds, in seconds, of the histogram buckets
+var latencyBounds = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// maxPendingAge is how long a call without a reply is remembered, calls
+// that time out locally or whose peer goes away are never answered
+const maxPendingAge = 5 * time.Minute
+
+// histogram aggregates the latencies of one method.
+type histogram struct {
+	count   uint64
+	errors  uint64
+	sum     float64
+	buckets []uint64 // one per bound, plus +Inf
+}
+
+func (h *histogram) observe(d time.Duration, failed bool) {
+	if h.buckets == nil {
+		h.buckets = make([]uint64, len(latencyBounds)+1)
+	}
+
+	s := d.Seconds()
+	h.count++
+	h.sum += s
+	if failed {
+		h.errors++
+	}
+
+	i := sort.SearchFloat64s(latencyBounds, s)
+	h.buckets[i]++
+}
+
+func (h *histogram) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteString(`{"count":`)
+	b.WriteString(strconv.FormatUint(h.count, 10))
+	b.WriteString(`,"errors":`)
+	b.WriteString(strconv.FormatUint(h.errors, 10))
+	b.WriteString(`,"sum":`)
+	b.WriteString(strconv.FormatFloat(h.sum, 'g', -1, 64))
+
+	// Buckets are cumulative, counting calls up to and including each bound
+	b.WriteString(`,"buckets":{`)
+	var n uint64
+	for i, c := range h.buckets {
+		n += c
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if i < len(latencyBounds) {
+			b.WriteString(strconv.Quote(strconv.FormatFloat(latencyBounds[i], 'g', -1, 64)))
+		} else {
+			b.WriteString(`"+Inf"`)
+		}
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatUint(n, 10))
+	}
+	b.WriteString("}}")
+
+	return b.Bytes(), nil
+}
+
+type pendingCall struct {
+	peer   string
+	method string
+	start  time.Time
+}
+
+// incomingKey identifies a call made to a connection, serials are only
+// unique per sender. On a peer-to-peer connection the sender is empty and
+// the serial alone identifies the call.
+type incomingKey struct {
+	sender string
+	serial uint32
+}
+
+// Tracer times the method calls made and served over the connections it is
+// installed on with WithTracer, logging each call and keeping per-method
+// latency histograms. One Tracer may be shared by several connections, the
+// histograms adding up the calls of all of them. A Tracer is an expvar.Var,
+// publishing it exports the histograms as {"calls": {...}, "served": {...}}
+// keyed by method.
+type Tracer struct {
+	// Logf is called for each completed call, nil disables logging
+	Logf func(format string, args ...interface{})
+
+	mu     sync.Mutex
+	calls  map[string]*histogram
+	served map[string]*histogram
+}
+
+// connTrace holds the calls pending on one connection, as serials are only
+// unique per connection. It is guarded by the mutex of its Tracer.
+type connTrace struct {
+	t        *Tracer
+	outgoing map[uint32]pendingCall
+	incoming map[incomingKey]pendingCall
+	pruned   time.Time
+}
+
+// NewTracer returns a Tracer logging through the log package, published
+// with expvar under name unless name is empty. As with expvar.Publish, it
+// panics if name is already in use.
+func NewTracer(name string) *Tracer {
+	t := &Tracer{
+		Logf:   log.Printf,
+		calls:  make(map[string]*histogram),
+		served: make(map[string]*histogram),
+	}
+
+	if name != "" {
+		expvar.Publish(name, t)
+	}
+
+	return t
+}
+
+// WithTracer installs t as both the incoming and outgoing interceptor of a
+// connection, replacing any set by earlier options, e.g.
+//
+//   conn, err := dbus.Connect(address, dbus.WithTracer(t))
+//
+// Use WithInterceptors to combine it with a Recorder.
+func WithTracer(t *Tracer) godbus.ConnOption {
+	return WithInterceptors(t)
+}
+
+func (t *Tracer) interceptors() (godbus.Interceptor, godbus.Interceptor) {
+	c := &connTrace{
+		t:        t,
+		outgoing: make(map[uint32]pendingCall),
+		incoming: make(map[incomingKey]pendingCall),
+		pruned:   time.Now(),
+	}
+
+	return c.incomingMessage, c.outgoingMessage
+}
+
+func header(msg *godbus.Message, field godbus.HeaderField) string {
+	v, ok := msg.Headers[field]
+	if !ok {
+		return ""
+	}
+
+	switch s := v.Value().(type) {
+	case string:
+		return s
+	case godbus.ObjectPath:
+		return string(s)
+	}
+
+	return ""
+}
+
+func replySerial(msg *godbus.Message) (uint32, bool) {
+	v, ok := msg.Headers[godbus.FieldReplySerial]
+	if !ok {
+		return 0, false
+	}
+
+	serial, ok := v.Value().(uint32)
+	return serial, ok
+}
+
+func methodName(msg *godbus.Message) string {
+	return header(msg, godbus.FieldInterface) + "." + header(msg, godbus.FieldMember)
+}
+
+func expectsReply(msg *godbus.Message) bool {
+	return msg.Type == godbus.TypeMethodCall && msg.Flags&godbus.FlagNoReplyExpected == 0
+}
+
+func (c *connTrace) outgoingMessage(msg *godbus.Message) {
+	now := time.Now()
+
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+
+	switch msg.Type {
+	case godbus.TypeMethodCall:
+		if expectsReply(msg) {
+			c.prune(now)
+			c.outgoing[msg.Serial()] = pendingCall{header(msg, godbus.FieldDestination), methodName(msg), now}
+		}
+	case godbus.TypeMethodReply, godbus.TypeError:
+		serial, ok := replySerial(msg)
+		if !ok {
+			return
+		}
+		key := incomingKey{header(msg, godbus.FieldDestination), serial}
+		if p, ok := c.incoming[key]; ok {
+			delete(c.incoming, key)
+			c.t.complete(c.t.served, "<-", p, now, msg)
+		}
+	}
+}
+
+func (c *connTrace) incomingMessage(msg *godbus.Message) {
+	now := time.Now()
+
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+
+	switch msg.Type {
+	case godbus.TypeMethodCall:
+		if expectsReply(msg) {
+			c.prune(now)
+			sender := header(msg, godbus.FieldSender)
+			c.incoming[incomingKey{sender, msg.Serial()}] = pendingCall{sender, methodName(msg), now}
+		}
+	case godbus.TypeMethodReply, godbus.TypeError:
+		serial, ok := replySerial(msg)
+		if !ok {
+			return
+		}
+		if p, ok := c.outgoing[serial]; ok {
+			delete(c.outgoing, serial)
+			c.t.complete(c.t.calls, "->", p, now, msg)
+		}
+	}
+}
+
+// complete records a call answered by reply, dir being "->" for calls made
+// by this connection and "<-" for calls it served
+func (t *Tracer) complete(hists map[string]*histogram, dir string, c pendingCall, now time.Time, reply *godbus.Message) {
+	d := now.Sub(c.start)
+	errName := ""
+	if reply.Type == godbus.TypeError {
+		errName = header(reply, godbus.FieldErrorName)
+	}
+
+	h, ok := hists[c.method]
+	if !ok {
+		h = &histogram{}
+		hists[c.method] = h
+	}
+	h.observe(d, reply.Type == godbus.TypeError)
+
+	if t.Logf == nil {
+		return
+	}
+	if errName != "" {
+		t.Logf("dbus: %s %s %s %v %s", dir, c.peer, c.method, d, errName)
+	} else {
+		t.Logf("dbus: %s %s %s %v", dir, c.peer, c.method, d)
+	}
+}
+
+// prune forgets calls that have waited too long for a reply, at most once
+// per maxPendingAge
+func (c *connTrace) prune(now time.Time) {
+	if now.Sub(c.pruned) < maxPendingAge {
+		return
+	}
+	c.pruned = now
+
+	for k, p := range c.outgoing {
+		if now.Sub(p.start) > maxPendingAge {
+			delete(c.outgoing, k)
+		}
+	}
+	for k, p := range c.incoming {
+		if now.Sub(p.start) > maxPendingAge {
+			delete(c.incoming, k)
+		}
+	}
+}
+
+// String returns the histograms as JSON, implementing expvar.Var.
+func (t *Tracer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, err := json.Marshal(map[string]map[string]*histogram{
+		"calls":  t.calls,
+		"served": t.served,
+	})
+	if err != nil {
+		return "{}"
+	}
+
+	return string(b)
+}
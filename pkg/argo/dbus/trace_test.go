@@ -0,0 +1,163 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+func TestTracer(t *testing.T) {
+	tracer := NewTracer("")
+	var logged []string
+	tracer.Logf = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	client, server := peerPair(t, WithTracer(tracer))
+	defer client.Close()
+	defer server.Close()
+
+	if err := server.Export(echo{}, "/test", "org.openxt.Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := client.Object("", "/test")
+	for i := 0; i < 3; i++ {
+		if err := obj.Call("org.openxt.Test.Echo", 0, "hi").Err; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := obj.Call("org.openxt.Test.Missing", 0).Err; err == nil {
+		t.Fatal("call to missing method succeeded")
+	}
+
+	var vars struct {
+		Calls map[string]struct {
+			Count   uint64
+			Errors  uint64
+			Buckets map[string]uint64
+		}
+	}
+	if err := json.Unmarshal([]byte(tracer.String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+
+	e := vars.Calls["org.openxt.Test.Echo"]
+	if e.Count != 3 || e.Errors != 0 || e.Buckets["+Inf"] != 3 {
+		t.Errorf("Echo histogram = %+v", e)
+	}
+	if missing := vars.Calls["org.openxt.Test.Missing"]; missing.Count != 1 || missing.Errors != 1 {
+		t.Errorf("Missing histogram = %+v", missing)
+	}
+
+	if len(logged) != 4 {
+		t.Fatalf("logged %d calls: %q", len(logged), logged)
+	}
+	if !strings.HasSuffix(logged[3], "org.freedesktop.DBus.Error.UnknownMethod") {
+		t.Errorf("error call logged as %q", logged[3])
+	}
+}
+
+// gate holds each call until released, so that calls overlap
+type gate struct {
+	arrived chan struct{}
+	release chan struct{}
+}
+
+func (g gate) Wait() *godbus.Error {
+	g.arrived <- struct{}{}
+	<-g.release
+	return nil
+}
+
+func callCounts(t *testing.T, tracer *Tracer) map[string]uint64 {
+	var vars struct {
+		Calls map[string]struct{ Count uint64 }
+	}
+	if err := json.Unmarshal([]byte(tracer.String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]uint64)
+	for m, h := range vars.Calls {
+		counts[m] = h.Count
+	}
+	return counts
+}
+
+func TestTracerSharedConnections(t *testing.T) {
+	tracer := NewTracer("")
+	tracer.Logf = nil
+
+	g := gate{arrived: make(chan struct{}), release: make(chan struct{})}
+
+	// Both clients number their first call the same
+	var clients []*godbus.Conn
+	for i := 0; i < 2; i++ {
+		client, server := peerPair(t, WithTracer(tracer))
+		defer client.Close()
+		defer server.Close()
+		if err := server.Export(g, "/gate", "org.openxt.Test"); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *godbus.Conn) {
+			defer wg.Done()
+			if err := c.Object("", "/gate").Call("org.openxt.Test.Wait", 0).Err; err != nil {
+				t.Error(err)
+			}
+		}(c)
+	}
+	<-g.arrived
+	<-g.arrived
+	close(g.release)
+	wg.Wait()
+
+	if n := callCounts(t, tracer)["org.openxt.Test.Wait"]; n != 2 {
+		t.Errorf("traced %d overlapping calls, want 2", n)
+	}
+}
+
+func TestWithInterceptors(t *testing.T) {
+	tracer := NewTracer("")
+	tracer.Logf = nil
+
+	var buf bytes.Buffer
+	recorder, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := peerPair(t, WithInterceptors(tracer, recorder))
+	defer client.Close()
+	defer server.Close()
+
+	if err := server.Export(echo{}, "/test", "org.openxt.Test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Object("", "/test").Call("org.openxt.Test.Echo", 0, "hi").Err; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := callCounts(t, tracer)["org.openxt.Test.Echo"]; n != 1 {
+		t.Errorf("traced %d calls, want 1", n)
+	}
+
+	rec, err := ReadRecording(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Messages) != 2 || !rec.Messages[0].Outgoing || rec.Messages[1].Outgoing {
+		t.Errorf("recorded %d messages, want the call and its reply", len(rec.Messages))
+	}
+}
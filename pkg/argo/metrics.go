@@ -0,0 +1,317 @@
+// +build !libargo
+
+package argo
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsKey labels traffic by the peer domain and the service port, which
+// is the remote port of dialed connections and the local port of accepted
+// ones, so ephemeral ports do not create a new series per connection.
+type MetricsKey struct {
+	Domain DomainId
+	Port   uint32
+}
+
+// PeerStats are the counters kept for each MetricsKey. The fields are
+// updated atomically and should be read with Metrics.Snapshot.
+type PeerStats struct {
+	BytesIn        uint64
+	BytesOut       uint64
+	MessagesIn     uint64
+	MessagesOut    uint64
+	Connections    uint64
+	Open           int64
+	ConnectErrors  uint64
+	ConnectCount   uint64
+	ConnectLatency int64 // total nanoseconds over ConnectCount
+}
+
+// ListenerStats are the counters kept for each listening port.
+type ListenerStats struct {
+	Accepts      uint64
+	AcceptErrors uint64
+}
+
+// Metrics counts the traffic of wrapped connections and listeners. A nil
+// *Metrics is valid: it returns connections and listeners unwrapped, so
+// disabled metrics cost nothing on the data path, and reports no counters.
+type Metrics struct {
+	mu        sync.Mutex
+	peers     map[MetricsKey]*PeerStats
+	listeners map[uint32]*ListenerStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		peers:     make(map[MetricsKey]*PeerStats),
+		listeners: make(map[uint32]*ListenerStats),
+	}
+}
+
+func (m *Metrics) peer(k MetricsKey) *PeerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.peers[k]
+	if !ok {
+		s = &PeerStats{}
+		m.peers[k] = s
+	}
+
+	return s
+}
+
+func (m *Metrics) listener(port uint32) *ListenerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.listeners[port]
+	if !ok {
+		s = &ListenerStats{}
+		m.listeners[port] = s
+	}
+
+	return s
+}
+
+// Dial connects with d, recording the connect latency or error, and returns
+// the connection wrapped for counting. A nil d is the zero Dialer.
+func (m *Metrics) Dial(d *Dialer, sockType, domid, port int) (net.Conn, error) {
+	if d == nil {
+		d = &Dialer{}
+	}
+
+	if m == nil {
+		c, err := d.Dial(sockType, domid, port)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	s := m.peer(MetricsKey{Domain: DomainId(domid), Port: uint32(port)})
+
+	start := time.Now()
+	c, err := d.Dial(sockType, domid, port)
+	if err != nil {
+		atomic.AddUint64(&s.ConnectErrors, 1)
+		return nil, err
+	}
+	atomic.AddInt64(&s.ConnectLatency, int64(time.Since(start)))
+	atomic.AddUint64(&s.ConnectCount, 1)
+
+	return m.wrap(c, s), nil
+}
+
+// Conn wraps an outgoing connection for counting.
+func (m *Metrics) Conn(conn net.Conn) net.Conn {
+	if m == nil {
+		return conn
+	}
+
+	r := toAddr(conn.RemoteAddr())
+
+	return m.wrap(conn, m.peer(MetricsKey{Domain: r.Domain, Port: r.Port}))
+}
+
+// Listener wraps l so that accepts and accepted connections are counted.
+func (m *Metrics) Listener(l net.Listener) net.Listener {
+	if m == nil {
+		return l
+	}
+
+	port := toAddr(l.Addr()).Port
+
+	return &metricsListener{Listener: l, m: m, port: port, stats: m.listener(port)}
+}
+
+func (m *Metrics) wrap(conn net.Conn, s *PeerStats) net.Conn {
+	atomic.AddUint64(&s.Connections, 1)
+	atomic.AddInt64(&s.Open, 1)
+
+	return &metricsConn{Conn: conn, s: s}
+}
+
+type metricsConn struct {
+	net.Conn
+	s         *PeerStats
+	closeOnce sync.Once
+}
+
+func (c *metricsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddUint64(&c.s.BytesIn, uint64(n))
+		atomic.AddUint64(&c.s.MessagesIn, 1)
+	}
+	return n, err
+}
+
+func (c *metricsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		atomic.AddUint64(&c.s.BytesOut, uint64(n))
+		atomic.AddUint64(&c.s.MessagesOut, 1)
+	}
+	return n, err
+}
+
+func (c *metricsConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(&c.s.Open, -1)
+	})
+	return c.Conn.Close()
+}
+
+type metricsListener struct {
+	net.Listener
+	m     *Metrics
+	port  uint32
+	stats *ListenerStats
+}
+
+func (l *metricsListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		atomic.AddUint64(&l.stats.AcceptErrors, 1)
+		return nil, err
+	}
+	atomic.AddUint64(&l.stats.Accepts, 1)
+
+	r := toAddr(c.RemoteAddr())
+
+	return l.m.wrap(c, l.m.peer(MetricsKey{Domain: r.Domain, Port: l.port})), nil
+}
+
+// Snapshot returns a consistent copy of the current counters.
+func (m *Metrics) Snapshot() (map[MetricsKey]PeerStats, map[uint32]ListenerStats) {
+	if m == nil {
+		return map[MetricsKey]PeerStats{}, map[uint32]ListenerStats{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make(map[MetricsKey]PeerStats, len(m.peers))
+	for k, s := range m.peers {
+		peers[k] = PeerStats{
+			BytesIn:        atomic.LoadUint64(&s.BytesIn),
+			BytesOut:       atomic.LoadUint64(&s.BytesOut),
+			MessagesIn:     atomic.LoadUint64(&s.MessagesIn),
+			MessagesOut:    atomic.LoadUint64(&s.MessagesOut),
+			Connections:    atomic.LoadUint64(&s.Connections),
+			Open:           atomic.LoadInt64(&s.Open),
+			ConnectErrors:  atomic.LoadUint64(&s.ConnectErrors),
+			ConnectCount:   atomic.LoadUint64(&s.ConnectCount),
+			ConnectLatency: atomic.LoadInt64(&s.ConnectLatency),
+		}
+	}
+
+	listeners := make(map[uint32]ListenerStats, len(m.listeners))
+	for p, s := range m.listeners {
+		listeners[p] = ListenerStats{
+			Accepts:      atomic.LoadUint64(&s.Accepts),
+			AcceptErrors: atomic.LoadUint64(&s.AcceptErrors),
+		}
+	}
+
+	return peers, listeners
+}
+
+// Publish exports the counters through expvar under name.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		peers, listeners := m.Snapshot()
+
+		p := make(map[string]PeerStats, len(peers))
+		for k, s := range peers {
+			p[fmt.Sprintf("%d:%d", k.Domain, k.Port)] = s
+		}
+
+		l := make(map[string]ListenerStats, len(listeners))
+		for port, s := range listeners {
+			l[fmt.Sprint(port)] = s
+		}
+
+		return map[string]interface{}{"peers": p, "listeners": l}
+	}))
+}
+
+// ServeHTTP writes the counters in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	peers, listeners := m.Snapshot()
+
+	keys := make([]MetricsKey, 0, len(peers))
+	for k := range peers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Domain != keys[j].Domain {
+			return keys[i].Domain < keys[j].Domain
+		}
+		return keys[i].Port < keys[j].Port
+	})
+
+	ports := make([]uint32, 0, len(listeners))
+	for p := range listeners {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	peerMetrics := []struct {
+		name, typ, help string
+		value           func(s PeerStats) string
+	}{
+		{"argo_bytes_received_total", "counter", "Bytes read from argo connections.",
+			func(s PeerStats) string { return fmt.Sprint(s.BytesIn) }},
+		{"argo_bytes_sent_total", "counter", "Bytes written to argo connections.",
+			func(s PeerStats) string { return fmt.Sprint(s.BytesOut) }},
+		{"argo_messages_received_total", "counter", "Reads returning data from argo connections.",
+			func(s PeerStats) string { return fmt.Sprint(s.MessagesIn) }},
+		{"argo_messages_sent_total", "counter", "Writes to argo connections.",
+			func(s PeerStats) string { return fmt.Sprint(s.MessagesOut) }},
+		{"argo_connections_total", "counter", "Argo connections established.",
+			func(s PeerStats) string { return fmt.Sprint(s.Connections) }},
+		{"argo_connections_open", "gauge", "Argo connections currently open.",
+			func(s PeerStats) string { return fmt.Sprint(s.Open) }},
+		{"argo_connect_errors_total", "counter", "Failed argo connection attempts.",
+			func(s PeerStats) string { return fmt.Sprint(s.ConnectErrors) }},
+	}
+
+	for _, pm := range peerMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", pm.name, pm.help, pm.name, pm.typ)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{domain=\"%d\",port=\"%d\"} %s\n", pm.name, k.Domain, k.Port, pm.value(peers[k]))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP argo_connect_latency_seconds Time spent in successful argo connects.\n# TYPE argo_connect_latency_seconds summary\n")
+	for _, k := range keys {
+		s := peers[k]
+		fmt.Fprintf(w, "argo_connect_latency_seconds_sum{domain=\"%d\",port=\"%d\"} %v\n",
+			k.Domain, k.Port, time.Duration(s.ConnectLatency).Seconds())
+		fmt.Fprintf(w, "argo_connect_latency_seconds_count{domain=\"%d\",port=\"%d\"} %d\n",
+			k.Domain, k.Port, s.ConnectCount)
+	}
+
+	fmt.Fprintf(w, "# HELP argo_accepts_total Connections accepted by argo listeners.\n# TYPE argo_accepts_total counter\n")
+	for _, p := range ports {
+		fmt.Fprintf(w, "argo_accepts_total{port=\"%d\"} %d\n", p, listeners[p].Accepts)
+	}
+
+	fmt.Fprintf(w, "# HELP argo_accept_errors_total Failed accepts on argo listeners.\n# TYPE argo_accept_errors_total counter\n")
+	for _, p := range ports {
+		fmt.Fprintf(w, "argo_accept_errors_total{port=\"%d\"} %d\n", p, listeners[p].AcceptErrors)
+	}
+}
@@ -0,0 +1,92 @@
+// +build !libargo
+
+package argo
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	pl := NewPipeListener(Addr{Domain: 0, Port: 5555})
+	defer pl.Close()
+	l := m.Listener(pl)
+
+	go func() {
+		c, err := pl.Dial(Addr{Domain: 3, Port: 40000})
+		if err != nil {
+			return
+		}
+		c.Write([]byte("hello"))
+		io.ReadFull(c, make([]byte, 3))
+		c.Close()
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if _, err := c.Write([]byte("bye")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	peers, listeners := m.Snapshot()
+	s := peers[MetricsKey{Domain: 3, Port: 5555}]
+	if s.BytesIn != 5 || s.BytesOut != 3 || s.Connections != 1 || s.Open != 1 {
+		t.Errorf("unexpected peer stats %+v", s)
+	}
+	if listeners[5555].Accepts != 1 {
+		t.Errorf("unexpected listener stats %+v", listeners[5555])
+	}
+
+	c.Close()
+	c.Close()
+	peers, _ = m.Snapshot()
+	if open := peers[MetricsKey{Domain: 3, Port: 5555}].Open; open != 0 {
+		t.Errorf("open connections %d after close, want 0", open)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`argo_bytes_received_total{domain="3",port="5555"} 5`,
+		`argo_bytes_sent_total{domain="3",port="5555"} 3`,
+		`argo_accepts_total{port="5555"} 1`,
+		"# TYPE argo_connect_latency_seconds summary",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	var m *Metrics
+	pl := NewPipeListener(Addr{Domain: 0, Port: 5555})
+	defer pl.Close()
+
+	if l := m.Listener(pl); l != pl {
+		t.Errorf("nil Metrics wrapped listener")
+	}
+
+	a, _ := Pipe(Addr{Domain: 0, Port: 1}, Addr{Domain: 1, Port: 2})
+	if c := m.Conn(a); c != a {
+		t.Errorf("nil Metrics wrapped connection")
+	}
+
+	if peers, listeners := m.Snapshot(); len(peers) != 0 || len(listeners) != 0 {
+		t.Errorf("nil Metrics reported counters")
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Errorf("nil Metrics served status %d", rec.Code)
+	}
+}
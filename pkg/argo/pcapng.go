@@ -0,0 +1,109 @@
+package argo
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Minimal writer for the pcapng capture file format as read by Wireshark.
+
+const (
+	pcapngSectionHeader   = 0x0A0D0D0A
+	pcapngInterfaceDesc   = 0x00000001
+	pcapngEnhancedPacket  = 0x00000006
+	pcapngByteOrderMagic  = 0x1A2B3C4D
+	pcapngOptEndOfOpt     = 0
+	pcapngOptIfName       = 2
+	pcapngOptEpbFlags     = 2
+	pcapngEpbFlagInbound  = 1
+	pcapngEpbFlagOutbound = 2
+
+	// LINKTYPE_USER0, the records carry the argo capture header below
+	pcapngLinkTypeArgo = 147
+)
+
+type pcapngWriter struct {
+	w io.Writer
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+func (p *pcapngWriter) block(typ uint32, body []byte) error {
+	total := uint32(12 + len(body) + pad4(len(body)))
+
+	b := make([]byte, 0, total)
+	b = appendU32(b, typ)
+	b = appendU32(b, total)
+	b = append(b, body...)
+	b = append(b, make([]byte, pad4(len(body)))...)
+	b = appendU32(b, total)
+
+	_, err := p.w.Write(b)
+	return err
+}
+
+func appendU16(b []byte, v uint16) []byte {
+	var t [2]byte
+	binary.LittleEndian.PutUint16(t[:], v)
+	return append(b, t[:]...)
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	var t [4]byte
+	binary.LittleEndian.PutUint32(t[:], v)
+	return append(b, t[:]...)
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = appendU16(b, code)
+	b = appendU16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+func newPcapngWriter(w io.Writer, ifName string) (*pcapngWriter, error) {
+	p := &pcapngWriter{w: w}
+
+	// Section header, version 1.0 and unspecified section length
+	shb := appendU32(nil, pcapngByteOrderMagic)
+	shb = appendU16(shb, 1)
+	shb = appendU16(shb, 0)
+	shb = appendU32(shb, 0xFFFFFFFF)
+	shb = appendU32(shb, 0xFFFFFFFF)
+	if err := p.block(pcapngSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	// Single interface, no snap length and default microsecond timestamps
+	idb := appendU16(nil, pcapngLinkTypeArgo)
+	idb = appendU16(idb, 0)
+	idb = appendU32(idb, 0)
+	idb = appendOption(idb, pcapngOptIfName, []byte(ifName))
+	idb = appendOption(idb, pcapngOptEndOfOpt, nil)
+	if err := p.block(pcapngInterfaceDesc, idb); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *pcapngWriter) packet(ts time.Time, data []byte, flags uint32) error {
+	us := uint64(ts.UnixNano() / int64(time.Microsecond))
+
+	epb := appendU32(nil, 0)
+	epb = appendU32(epb, uint32(us>>32))
+	epb = appendU32(epb, uint32(us))
+	epb = appendU32(epb, uint32(len(data)))
+	epb = appendU32(epb, uint32(len(data)))
+	epb = append(epb, data...)
+	epb = append(epb, make([]byte, pad4(len(data)))...)
+	if flags != 0 {
+		epb = appendOption(epb, pcapngOptEpbFlags, appendU32(nil, flags))
+		epb = appendOption(epb, pcapngOptEndOfOpt, nil)
+	}
+
+	return p.block(pcapngEnhancedPacket, epb)
+}
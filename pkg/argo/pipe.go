@@ -0,0 +1,85 @@
+package argo
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// PipeListener is an in-memory stand in for a Listener. Connections made
+// with Dial are synchronous net.Pipe pairs whose addresses report the argo
+// domains and ports given, so code written against net.Conn and
+// net.Listener can be exercised without the argo driver.
+type PipeListener struct {
+	addr  Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+var ErrPipeClosed = errors.New("argo: pipe listener closed")
+
+type pipeConn struct {
+	net.Conn
+	local  Addr
+	remote Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Pipe returns a connected in-memory pair, the first end has address a and
+// the second address b.
+func Pipe(a, b Addr) (net.Conn, net.Conn) {
+	ca, cb := net.Pipe()
+
+	return &pipeConn{Conn: ca, local: a, remote: b},
+		&pipeConn{Conn: cb, local: b, remote: a}
+}
+
+func NewPipeListener(addr Addr) *PipeListener {
+	return &PipeListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Dial connects to the listener from the address from, blocking until the
+// connection is accepted.
+func (l *PipeListener) Dial(from Addr) (net.Conn, error) {
+	c, s := Pipe(from, l.addr)
+
+	select {
+	case l.conns <- s:
+		return c, nil
+	case <-l.done:
+		return nil, ErrPipeClosed
+	}
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, ErrPipeClosed
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr {
+	return l.addr
+}
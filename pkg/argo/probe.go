@@ -0,0 +1,98 @@
+// +build !libargo
+
+package argo
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// IoctlProbe is the outcome of issuing one driver request during ProbeIoctls.
+// Err is nil when the driver accepted the request, syscall.ENOTTY when the
+// driver does not know the request and ErrNotProbed when it was not issued.
+type IoctlProbe struct {
+	Name string
+	Err  error
+}
+
+var ErrNotProbed = errors.New("not probed")
+
+// ProbeIoctls exercises each argo driver request against real sockets by
+// listening on port and connecting to it from domid, which should be the
+// local domain. The firewall add and delete requests are not issued since
+// they would modify the running configuration.
+func ProbeIoctls(domid DomainId, port int) []IoctlProbe {
+	var probes []IoctlProbe
+	var l, c, a *os.File
+
+	result := func(name string, err error) error {
+		probes = append(probes, IoctlProbe{Name: name, Err: err})
+		return err
+	}
+	skip := func(names ...string) []IoctlProbe {
+		for _, n := range names {
+			result(n, ErrNotProbed)
+		}
+		return probes
+	}
+	defer func() {
+		for _, f := range []*os.File{l, c, a} {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+
+	lc, err := open(syscall.SOCK_STREAM, XEN_ARGO_DOMID_ANY, port)
+	if err != nil {
+		return skip("SETRINGSIZE", "GETSOCKTYPE", "BIND", "GETSOCKNAME",
+			"LISTEN", "CONNECT", "GETCONNECTERR", "GETPEERNAME", "ACCEPT",
+			"VIPTABLES_LIST", "VIPTABLES_ADD", "VIPTABLES_DEL")
+	}
+	l = lc.file
+
+	result("SETRINGSIZE", setRingSize(l, 64*1024))
+
+	_, err = getIntIoctl(l, argoIocGetSockType)
+	result("GETSOCKTYPE", err)
+
+	ring := RingId{Domain: XEN_ARGO_DOMID_ANY, Partner: XEN_ARGO_DOMID_ANY, Port: uint32(port)}
+	bindErr := result("BIND", bind(l, ring))
+
+	_, err = getSockName(l)
+	result("GETSOCKNAME", err)
+
+	listenErr := result("LISTEN", listen(l, 1))
+
+	cc, err := open(syscall.SOCK_STREAM, int(domid), port)
+	if err == nil {
+		c = cc.file
+	}
+	if c == nil || bindErr != nil || listenErr != nil {
+		skip("CONNECT", "GETCONNECTERR", "GETPEERNAME", "ACCEPT")
+	} else {
+		connectErr := result("CONNECT", connect(c, cc.addr))
+
+		_, err = getIntIoctl(c, argoIocGetConnectErr)
+		result("GETCONNECTERR", err)
+
+		if connectErr != nil {
+			skip("GETPEERNAME", "ACCEPT")
+		} else {
+			_, err = getPeerName(c)
+			result("GETPEERNAME", err)
+
+			ac, err := accept(l)
+			if err == nil {
+				a = ac.file
+			}
+			result("ACCEPT", err)
+		}
+	}
+
+	_, err = viptablesList(l, 0)
+	result("VIPTABLES_LIST", err)
+
+	return skip("VIPTABLES_ADD", "VIPTABLES_DEL")
+}
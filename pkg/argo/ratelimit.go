@@ -0,0 +1,220 @@
+package argo
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimit configures the token buckets applied to a source domain. Zero
+// rates are unlimited and zero bursts default to one second of the rate.
+type RateLimit struct {
+	BytesPerSec    float64
+	BytesBurst     float64
+	MessagesPerSec float64
+	MessagesBurst  float64
+	AcceptsPerSec  float64
+	AcceptsBurst   float64
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// reserve takes n tokens, going into debt if needed, and returns how long
+// the caller must wait for the debt to be repaid.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) wait(n float64) {
+	if d := b.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// allow takes a token if one is available without waiting.
+func (b *tokenBucket) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// domainBuckets are shared by all connections from one domain so opening
+// more connections does not raise a domain's share.
+type domainBuckets struct {
+	bytes    *tokenBucket
+	messages *tokenBucket
+	accepts  *tokenBucket
+}
+
+// RateLimiter applies token bucket limits to connections and listeners per
+// source domain. Domains without a limit set with SetDomain use the default.
+type RateLimiter struct {
+	mu      sync.Mutex
+	def     RateLimit
+	limits  map[DomainId]RateLimit
+	buckets map[DomainId]*domainBuckets
+}
+
+func NewRateLimiter(def RateLimit) *RateLimiter {
+	return &RateLimiter{
+		def:     def,
+		limits:  make(map[DomainId]RateLimit),
+		buckets: make(map[DomainId]*domainBuckets),
+	}
+}
+
+// SetDomain sets the limits for domid, replacing its current buckets.
+func (rl *RateLimiter) SetDomain(domid DomainId, limit RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.limits[domid] = limit
+	delete(rl.buckets, domid)
+}
+
+func (rl *RateLimiter) domain(domid DomainId) *domainBuckets {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b, ok := rl.buckets[domid]
+	if !ok {
+		limit, ok := rl.limits[domid]
+		if !ok {
+			limit = rl.def
+		}
+		b = &domainBuckets{
+			bytes:    newTokenBucket(limit.BytesPerSec, limit.BytesBurst),
+			messages: newTokenBucket(limit.MessagesPerSec, limit.MessagesBurst),
+			accepts:  newTokenBucket(limit.AcceptsPerSec, limit.AcceptsBurst),
+		}
+		rl.buckets[domid] = b
+	}
+
+	return b
+}
+
+// Conn limits the reads and writes of conn by its remote domain.
+func (rl *RateLimiter) Conn(conn net.Conn) net.Conn {
+	return &rateLimitConn{Conn: conn, b: rl.domain(toAddr(conn.RemoteAddr()).Domain)}
+}
+
+// Listener limits the accept rate of l per source domain, connections over
+// a domain's rate are closed without being returned so that one domain
+// cannot starve the others. Accepted connections are limited as by Conn.
+func (rl *RateLimiter) Listener(l net.Listener) net.Listener {
+	return &rateLimitListener{Listener: l, rl: rl}
+}
+
+type rateLimitConn struct {
+	net.Conn
+	b *domainBuckets
+}
+
+func (c *rateLimitConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		// Delaying the next read leaves data in the ring which in turn
+		// blocks the sender
+		c.b.messages.wait(1)
+		c.b.bytes.wait(float64(n))
+	}
+	return n, err
+}
+
+func (c *rateLimitConn) Write(p []byte) (int, error) {
+	c.b.messages.wait(1)
+
+	// Write in chunks no larger than the burst so the rate holds for
+	// large buffers
+	var written int
+	for len(p) > 0 {
+		chunk := len(p)
+		if c.b.bytes != nil && float64(chunk) > c.b.bytes.burst {
+			chunk = int(c.b.bytes.burst)
+			if chunk < 1 {
+				chunk = 1
+			}
+		}
+		c.b.bytes.wait(float64(chunk))
+
+		n, err := c.Conn.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+type rateLimitListener struct {
+	net.Listener
+	rl *RateLimiter
+}
+
+func (l *rateLimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		b := l.rl.domain(toAddr(c.RemoteAddr()).Domain)
+		if !b.accepts.allow() {
+			c.Close()
+			continue
+		}
+
+		return &rateLimitConn{Conn: c, b: b}, nil
+	}
+}
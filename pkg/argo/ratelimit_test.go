@@ -0,0 +1,54 @@
+package argo
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimitAccept(t *testing.T) {
+	rl := NewRateLimiter(RateLimit{})
+	rl.SetDomain(3, RateLimit{AcceptsPerSec: 0.001, AcceptsBurst: 1})
+
+	pl := NewPipeListener(Addr{Domain: 0, Port: 5555})
+	defer pl.Close()
+	l := rl.Listener(pl)
+
+	go func() {
+		for _, d := range []DomainId{3, 3, 4} {
+			if _, err := pl.Dial(Addr{Domain: d, Port: 40000}); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, want := range []DomainId{3, 4} {
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept error: %v", err)
+		}
+		if d := c.RemoteAddr().(Addr).Domain; d != want {
+			t.Errorf("accepted domain %d, want %d", d, want)
+		}
+	}
+}
+
+func TestRateLimitBytes(t *testing.T) {
+	rl := NewRateLimiter(RateLimit{BytesPerSec: 10000, BytesBurst: 1000})
+	a, b := Pipe(Addr{Domain: 0, Port: 1}, Addr{Domain: 3, Port: 2})
+	c := rl.Conn(a)
+
+	go io.Copy(ioutil.Discard, b)
+
+	start := time.Now()
+	n, err := c.Write(make([]byte, 3000))
+	if err != nil || n != 3000 {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+
+	// The first 1000 bytes are the burst, the rest take 200ms at the rate
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3000 bytes written in %v, expected rate limiting", elapsed)
+	}
+}
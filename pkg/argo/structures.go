@@ -67,3 +67,20 @@ type Listener struct {
 	conn *Conn
 	ring RingId
 }
+
+// Dialer contains options for connecting to an argo address.
+type Dialer struct {
+	// RingSize is the size in bytes of the receive ring, zero leaves the
+	// driver default in place.
+	RingSize uint32
+}
+
+// ListenConfig contains options for listening on an argo port.
+type ListenConfig struct {
+	// RingSize is the size in bytes of the receive ring, zero leaves the
+	// driver default in place. It is inherited by accepted connections.
+	RingSize uint32
+
+	// Backlog is the pending connection queue length, zero uses 5.
+	Backlog int
+}
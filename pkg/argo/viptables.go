@@ -0,0 +1,297 @@
+// +build !libargo
+
+package argo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	XEN_ARGO_VIPTABLES_REJECT = 0
+	XEN_ARGO_VIPTABLES_ACCEPT = 1
+
+	// VIpTablesEnd as an add position appends the rule, as a delete
+	// position it deletes the first rule matching the one given.
+	VIpTablesEnd = -1
+
+	vIpTablesRuleSize     = 20 // struct xen_argo_viptables_rule
+	vIpTablesListHdrSize  = 8  // struct xen_argo_viptables_list header
+	vIpTablesListPageSize = 32 // rules fetched per list request
+)
+
+/*
+struct viptables_rule_pos {
+    struct xen_argo_viptables_rule* rule;	8
+    int position;				4
+    uint32_t pad;				4
+};
+
+struct xen_argo_viptables_list {
+    uint32_t start_rule;				4
+    uint32_t nr_rules;					4
+    struct xen_argo_viptables_rule rules[];	20 * nr_rules
+};
+*/
+
+func ruleFromC(r io.Reader, rule *VIpTablesRule) error {
+	var pad uint16
+
+	if err := addrFromC(r, &rule.Src); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &pad); err != nil {
+		return err
+	}
+
+	if err := addrFromC(r, &rule.Dst); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &pad); err != nil {
+		return err
+	}
+
+	return binary.Read(r, binary.LittleEndian, &rule.Accept)
+}
+
+func (rule *VIpTablesRule) toC(w io.Writer) error {
+	if err := rule.Src.toC(w); err != nil {
+		return err
+	}
+
+	if err := rule.Dst.toC(w); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, rule.Accept)
+}
+
+func openControl() (*os.File, error) {
+	return os.OpenFile("/dev/argo_stream", syscall.O_RDWR, 0666)
+}
+
+func viptablesList(file *os.File, start uint32) ([]VIpTablesRule, error) {
+	b := make([]byte, vIpTablesListHdrSize+vIpTablesListPageSize*vIpTablesRuleSize)
+	binary.LittleEndian.PutUint32(b[0:], start)
+	binary.LittleEndian.PutUint32(b[4:], vIpTablesListPageSize)
+
+	if err := ioctl(file, argoIocViptablesList, unsafe.Pointer(&b[0])); err != nil {
+		return nil, err
+	}
+
+	n := binary.LittleEndian.Uint32(b[4:])
+	if n > vIpTablesListPageSize {
+		n = vIpTablesListPageSize
+	}
+
+	buf := bytes.NewBuffer(b[vIpTablesListHdrSize:])
+	rules := make([]VIpTablesRule, n)
+	for i := range rules {
+		if err := ruleFromC(buf, &rules[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+func viptablesRulePos(file *os.File, req uintptr, rule *VIpTablesRule, position int) error {
+	var rb, pb bytes.Buffer
+
+	if err := rule.toC(&rb); err != nil {
+		return err
+	}
+	r := rb.Bytes()
+
+	// The rule is passed by reference and the buffer is not moved by the
+	// garbage collector, it only needs to be kept alive over the call.
+	binary.Write(&pb, binary.LittleEndian, uint64(uintptr(unsafe.Pointer(&r[0]))))
+	binary.Write(&pb, binary.LittleEndian, int32(position))
+	binary.Write(&pb, binary.LittleEndian, uint32(0))
+	p := pb.Bytes()
+
+	err := ioctl(file, req, unsafe.Pointer(&p[0]))
+	runtime.KeepAlive(r)
+
+	return err
+}
+
+// ViptablesAdd inserts rule into the argo firewall at the zero based
+// position, or appends it when position is VIpTablesEnd.
+func ViptablesAdd(rule VIpTablesRule, position int) error {
+	f, err := openControl()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return viptablesRulePos(f, argoIocViptablesAdd, &rule, position)
+}
+
+// ViptablesDel deletes the rule at the zero based position from the argo
+// firewall.
+func ViptablesDel(position int) error {
+	f, err := openControl()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return viptablesRulePos(f, argoIocViptablesDel, &VIpTablesRule{}, position)
+}
+
+// ViptablesDelRule deletes the first rule in the argo firewall matching rule.
+func ViptablesDelRule(rule VIpTablesRule) error {
+	f, err := openControl()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return viptablesRulePos(f, argoIocViptablesDel, &rule, VIpTablesEnd)
+}
+
+// ViptablesFlush deletes all rules from the argo firewall.
+func ViptablesFlush() error {
+	rules, err := ViptablesList()
+	if err != nil {
+		return err
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		if err := ViptablesDel(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ViptablesList returns the rules of the argo firewall in evaluation order.
+func ViptablesList() ([]VIpTablesRule, error) {
+	f, err := openControl()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []VIpTablesRule
+	for {
+		page, err := viptablesList(f, uint32(len(rules)))
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, page...)
+		if len(page) < vIpTablesListPageSize {
+			return rules, nil
+		}
+	}
+}
+
+func formatRuleAddr(a Addr) string {
+	dom, port := "any", "any"
+
+	if a.Domain != XEN_ARGO_DOMID_ANY {
+		dom = strconv.Itoa(int(a.Domain))
+	}
+	if a.Port != XEN_ARGO_PORT_ANY {
+		port = strconv.FormatUint(uint64(a.Port), 10)
+	}
+
+	return dom + ":" + port
+}
+
+// String formats the rule in the iptables-like syntax used by viptables,
+// e.g. "-s 1:any -d 0:5555 -j ACCEPT".
+func (rule VIpTablesRule) String() string {
+	target := "REJECT"
+	if rule.Accept == XEN_ARGO_VIPTABLES_ACCEPT {
+		target = "ACCEPT"
+	}
+
+	return "-s " + formatRuleAddr(rule.Src) + " -d " + formatRuleAddr(rule.Dst) + " -j " + target
+}
+
+func parseRuleAddr(s string) (Addr, error) {
+	a := Addr{Port: XEN_ARGO_PORT_ANY, Domain: XEN_ARGO_DOMID_ANY}
+
+	dom, port := s, "any"
+	if i := strings.IndexRune(s, ':'); i != -1 {
+		dom, port = s[:i], s[i+1:]
+	}
+
+	if dom != "any" && dom != "*" {
+		v, err := strconv.ParseUint(dom, 10, 16)
+		if err != nil {
+			return a, fmt.Errorf("invalid domain %q", dom)
+		}
+		a.Domain = DomainId(v)
+	}
+
+	if port != "any" && port != "*" {
+		v, err := strconv.ParseUint(port, 10, 32)
+		if err != nil {
+			return a, fmt.Errorf("invalid port %q", port)
+		}
+		a.Port = uint32(v)
+	}
+
+	return a, nil
+}
+
+// ParseVIpTablesRule parses a rule in the iptables-like syntax produced by
+// VIpTablesRule.String. Addresses take the form domain[:port] where either
+// part may be "any", omitted addresses match anything.
+func ParseVIpTablesRule(s string) (VIpTablesRule, error) {
+	rule := VIpTablesRule{
+		Src:    Addr{Port: XEN_ARGO_PORT_ANY, Domain: XEN_ARGO_DOMID_ANY},
+		Dst:    Addr{Port: XEN_ARGO_PORT_ANY, Domain: XEN_ARGO_DOMID_ANY},
+		Accept: XEN_ARGO_VIPTABLES_REJECT,
+	}
+	target := false
+
+	f := strings.Fields(s)
+	for i := 0; i < len(f); i += 2 {
+		if i+1 >= len(f) {
+			return rule, fmt.Errorf("missing value for %s", f[i])
+		}
+
+		var err error
+		switch f[i] {
+		case "-s", "--source":
+			rule.Src, err = parseRuleAddr(f[i+1])
+		case "-d", "--destination":
+			rule.Dst, err = parseRuleAddr(f[i+1])
+		case "-j", "--jump":
+			switch strings.ToUpper(f[i+1]) {
+			case "ACCEPT":
+				rule.Accept = XEN_ARGO_VIPTABLES_ACCEPT
+			case "REJECT", "DROP":
+				rule.Accept = XEN_ARGO_VIPTABLES_REJECT
+			default:
+				err = fmt.Errorf("invalid target %q", f[i+1])
+			}
+			target = true
+		default:
+			err = fmt.Errorf("unknown option %q", f[i])
+		}
+		if err != nil {
+			return rule, err
+		}
+	}
+
+	if !target {
+		return rule, fmt.Errorf("no target (-j ACCEPT|REJECT) given")
+	}
+
+	return rule, nil
+}
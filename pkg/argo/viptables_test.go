@@ -0,0 +1,88 @@
+// +build !libargo
+
+package argo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseVIpTablesRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want VIpTablesRule
+		out  string
+	}{
+		{
+			in: "-s 1:any -d 0:5555 -j ACCEPT",
+			want: VIpTablesRule{
+				Src:    Addr{Domain: 1, Port: XEN_ARGO_PORT_ANY},
+				Dst:    Addr{Domain: 0, Port: 5555},
+				Accept: XEN_ARGO_VIPTABLES_ACCEPT,
+			},
+			out: "-s 1:any -d 0:5555 -j ACCEPT",
+		},
+		{
+			in: "--destination 0 --jump reject",
+			want: VIpTablesRule{
+				Src:    Addr{Domain: XEN_ARGO_DOMID_ANY, Port: XEN_ARGO_PORT_ANY},
+				Dst:    Addr{Domain: 0, Port: XEN_ARGO_PORT_ANY},
+				Accept: XEN_ARGO_VIPTABLES_REJECT,
+			},
+			out: "-s any:any -d 0:any -j REJECT",
+		},
+		{
+			in: "-s *:80 -j DROP",
+			want: VIpTablesRule{
+				Src:    Addr{Domain: XEN_ARGO_DOMID_ANY, Port: 80},
+				Dst:    Addr{Domain: XEN_ARGO_DOMID_ANY, Port: XEN_ARGO_PORT_ANY},
+				Accept: XEN_ARGO_VIPTABLES_REJECT,
+			},
+			out: "-s any:80 -d any:any -j REJECT",
+		},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseVIpTablesRule(tt.in)
+		if err != nil {
+			t.Errorf("ParseVIpTablesRule(%q) error: %v", tt.in, err)
+			continue
+		}
+		if r != tt.want {
+			t.Errorf("ParseVIpTablesRule(%q) = %#v, want %#v", tt.in, r, tt.want)
+		}
+		if r.String() != tt.out {
+			t.Errorf("ParseVIpTablesRule(%q).String() = %q, want %q", tt.in, r.String(), tt.out)
+		}
+	}
+
+	for _, in := range []string{"", "-s 1", "-s 1 -j", "-s x -j ACCEPT", "-d 0:y -j ACCEPT", "-j DENY", "-x 1 -j ACCEPT"} {
+		if _, err := ParseVIpTablesRule(in); err == nil {
+			t.Errorf("ParseVIpTablesRule(%q) expected error", in)
+		}
+	}
+}
+
+func TestVIpTablesRuleEncoding(t *testing.T) {
+	rule := VIpTablesRule{
+		Src:    Addr{Domain: 3, Port: 1234},
+		Dst:    Addr{Domain: 0, Port: 5555},
+		Accept: XEN_ARGO_VIPTABLES_ACCEPT,
+	}
+
+	var buf bytes.Buffer
+	if err := rule.toC(&buf); err != nil {
+		t.Fatalf("toC error: %v", err)
+	}
+	if buf.Len() != vIpTablesRuleSize {
+		t.Fatalf("encoded rule is %d bytes, want %d", buf.Len(), vIpTablesRuleSize)
+	}
+
+	var r VIpTablesRule
+	if err := ruleFromC(&buf, &r); err != nil {
+		t.Fatalf("ruleFromC error: %v", err)
+	}
+	if r != rule {
+		t.Errorf("decoded rule %#v, want %#v", r, rule)
+	}
+}
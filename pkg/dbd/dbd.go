@@ -5,6 +5,13 @@ package dbd
 
 import (
 	"github.com/godbus/dbus/v5"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+const (
+	Service   = "com.citrix.xenclient.db"
+	Interface = "com.citrix.xenclient.db"
+	Path      = dbus.ObjectPath("/")
 )
 
 type Client interface {
@@ -20,17 +27,94 @@ type Client interface {
 
 type Dbd struct {
 	conn *dbus.Conn
+	dest string
+	path dbus.ObjectPath
+
+	// private is set when the client opened conn itself and closes it
+	private bool
 }
 
-func NewClient() (Client, error) {
-	conn, err := dbus.SystemBus()
+// Option changes where a client created by the New functions finds the DB
+// daemon.
+type Option func(*Dbd)
+
+// WithDestination has the client call the DB daemon under dest instead of
+// Service.
+func WithDestination(dest string) Option {
+	return func(c *Dbd) {
+		c.dest = dest
+	}
+}
+
+// WithPath has the client call the DB daemon object at path instead of
+// Path.
+func WithPath(path dbus.ObjectPath) Option {
+	return func(c *Dbd) {
+		c.path = path
+	}
+}
+
+func newClient(conn *dbus.Conn, private bool, opts []Option) *Dbd {
+	c := &Dbd{
+		conn:    conn,
+		dest:    Service,
+		path:    Path,
+		private: private,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// NewClient connects to the DB daemon over the system bus, as in dom0.
+func NewClient(opts ...Option) (Client, error) {
+	conn, err := argodbus.SystemBus()
 
 	if err != nil {
 		return nil, err
 	}
-	return &Dbd{
-		conn: conn,
-	}, nil
+	return newClient(conn, false, opts), nil
+}
+
+// NewPlatformClient connects to the DB daemon over the platform bus, which
+// from a guest or service VM is reached over argo.
+func NewPlatformClient(opts ...Option) (Client, error) {
+	conn, err := argodbus.PlatformBus()
+
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, false, opts), nil
+}
+
+// NewClientAddress connects to the DB daemon over a private connection to
+// the bus at address, which may be an argo address. Close closes the
+// connection.
+func NewClientAddress(address string, opts ...Option) (*Dbd, error) {
+	conn, err := argodbus.Connect(address)
+
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, true, opts), nil
+}
+
+// NewClientConn returns a client using an established connection.
+func NewClientConn(conn *dbus.Conn, opts ...Option) Client {
+	return newClient(conn, false, opts)
+}
+
+// Close closes the connection of a client created by NewClientAddress.
+// Shared bus connections and those passed in are left open for their
+// other users.
+func (c *Dbd) Close() error {
+	if !c.private {
+		return nil
+	}
+
+	return c.conn.Close()
 }
 
 //    <method name="read">
@@ -38,10 +122,10 @@ func NewClient() (Client, error) {
 //      <arg name="value" type="s" direction="out"/>
 //    </method>
 func (c *Dbd) Read(path string) (string, error) {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
 	var s string
-	err := obj.Call("com.citrix.xenclient.db.read", 0, path).Store(&s)
+	err := obj.Call(Interface+".read", 0, path).Store(&s)
 
 	return s, err
 }
@@ -51,10 +135,10 @@ func (c *Dbd) Read(path string) (string, error) {
 //      <arg name="value" type="ay" direction="out"/>
 //    </method>
 func (c *Dbd) ReadBinary(path string) ([]byte, error) {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
 	var b []byte
-	err := obj.Call("com.citrix.xenclient.db.read_binary", 0, path).Store(&b)
+	err := obj.Call(Interface+".read_binary", 0, path).Store(&b)
 
 	return b, err
 }
@@ -64,9 +148,9 @@ func (c *Dbd) ReadBinary(path string) ([]byte, error) {
 //      <arg name="value" type="s" direction="in"/>
 //    </method>
 func (c *Dbd) Write(path string, value string) error {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
-	call := obj.Call("com.citrix.xenclient.db.write", 0, path, value)
+	call := obj.Call(Interface+".write", 0, path, value)
 
 	return call.Err
 }
@@ -76,10 +160,10 @@ func (c *Dbd) Write(path string, value string) error {
 //      <arg name="value" type="s" direction="out"/>
 //    </method>
 func (c *Dbd) Dump(path string) (string, error) {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
 	var s string
-	err := obj.Call("com.citrix.xenclient.db.dump", 0, path).Store(&s)
+	err := obj.Call(Interface+".dump", 0, path).Store(&s)
 
 	return s, err
 }
@@ -89,9 +173,9 @@ func (c *Dbd) Dump(path string) (string, error) {
 //      <arg name="value" type="s" direction="in"/>
 //    </method>
 func (c *Dbd) Inject(path string, value string) error {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
-	call := obj.Call("com.citrix.xenclient.db.inject", 0, path, value)
+	call := obj.Call(Interface+".inject", 0, path, value)
 
 	return call.Err
 }
@@ -101,10 +185,10 @@ func (c *Dbd) Inject(path string, value string) error {
 //      <arg name="value" type="as" direction="out"/>
 //    </method>
 func (c *Dbd) List(path string) ([]string, error) {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
 	var s []string
-	err := obj.Call("com.citrix.xenclient.db.list", 0, path).Store(&s)
+	err := obj.Call(Interface+".list", 0, path).Store(&s)
 
 	return s, err
 }
@@ -113,9 +197,9 @@ func (c *Dbd) List(path string) ([]string, error) {
 //      <arg name="path" type="s" direction="in"/>
 //    </method>
 func (c *Dbd) Rm(path string) error {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
-	call := obj.Call("com.citrix.xenclient.db.rm", 0, path)
+	call := obj.Call(Interface+".rm", 0, path)
 
 	return call.Err
 }
@@ -125,10 +209,10 @@ func (c *Dbd) Rm(path string) error {
 //      <arg name="ex" type="b" direction="out"/>
 //    </method>
 func (c *Dbd) Exists(path string) (bool, error) {
-	obj := c.conn.Object("com.citrix.xenclient.db", "/")
+	obj := c.conn.Object(c.dest, c.path)
 
 	var b bool
-	err := obj.Call("com.citrix.xenclient.db.read", 0, path).Store(&b)
+	err := obj.Call(Interface+".exists", 0, path).Store(&b)
 
 	return b, err
 }
@@ -0,0 +1,94 @@
+package dbd
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+type fakeDb struct {
+	values map[string]string
+}
+
+func (d *fakeDb) Read(path string) (string, *dbus.Error) {
+	return d.values[path], nil
+}
+
+func (d *fakeDb) Write(path, value string) *dbus.Error {
+	d.values[path] = value
+	return nil
+}
+
+func (d *fakeDb) Exists(path string) (bool, *dbus.Error) {
+	_, ok := d.values[path]
+	return ok, nil
+}
+
+// testConn connects to a fake DB daemon serving path over a test peer,
+// returning both ends.
+func testConn(t *testing.T, path dbus.ObjectPath) (client, server *dbus.Conn) {
+	client, server, err := argodbus.NewTestPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &fakeDb{values: map[string]string{"/vm/1/name": "ndvm"}}
+	methods := map[string]string{"Read": "read", "Write": "write", "Exists": "exists"}
+	if err := server.ExportWithMap(db, methods, path, Interface); err != nil {
+		t.Fatal(err)
+	}
+
+	return client, server
+}
+
+func TestClient(t *testing.T) {
+	conn, server := testConn(t, Path)
+	defer server.Close()
+
+	c := NewClientConn(conn)
+
+	if v, err := c.Read("/vm/1/name"); err != nil || v != "ndvm" {
+		t.Errorf("Read = %q, %v", v, err)
+	}
+	if err := c.Write("/vm/1/name", "uivm"); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if v, err := c.Read("/vm/1/name"); err != nil || v != "uivm" {
+		t.Errorf("Read after Write = %q, %v", v, err)
+	}
+
+	// exists answers a boolean where read would answer a string
+	if ok, err := c.Exists("/vm/1/name"); err != nil || !ok {
+		t.Errorf("Exists = %v, %v", ok, err)
+	}
+	if ok, err := c.Exists("/vm/2"); err != nil || ok {
+		t.Errorf("Exists of a missing path = %v, %v", ok, err)
+	}
+
+	// The connection was passed in, so Close leaves it open
+	if err := c.(*Dbd).Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if _, err := c.Read("/vm/1/name"); err != nil {
+		t.Errorf("Read after Close error: %v", err)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	conn, server := testConn(t, "/db")
+	defer server.Close()
+	defer conn.Close()
+
+	if _, err := NewClientConn(conn).Read("/vm/1/name"); err == nil {
+		t.Errorf("Read from the default path succeeded")
+	}
+
+	c := NewClientConn(conn, WithDestination("org.openxt.db"), WithPath("/db"))
+	if d := c.(*Dbd); d.dest != "org.openxt.db" || d.path != "/db" {
+		t.Errorf("client calls %s %s", d.dest, d.path)
+	}
+	if v, err := c.Read("/vm/1/name"); err != nil || v != "ndvm" {
+		t.Errorf("Read = %q, %v", v, err)
+	}
+}
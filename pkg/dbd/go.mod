@@ -1,3 +1,10 @@
 module github.com/openxt/openxt-go/pkg/dbd
 
 go 1.12
+
+require (
+	github.com/godbus/dbus/v5 v5.0.3
+	github.com/openxt/openxt-go/pkg/argo v0.0.0
+)
+
+replace github.com/openxt/openxt-go/pkg/argo => ../argo
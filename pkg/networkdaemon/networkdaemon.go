@@ -0,0 +1,244 @@
+package networkdaemon
+
+import (
+	"github.com/godbus/dbus/v5"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+const (
+	Service          = "com.citrix.xenclient.networkdaemon"
+	Interface        = "com.citrix.xenclient.networkdaemon"
+	NotifyInterface  = "com.citrix.xenclient.networkdaemon.notify"
+	NetworkInterface = "com.citrix.xenclient.network"
+)
+
+// Network types accepted by CreateNetwork and reported by the type
+// property
+const (
+	TypeWired    = "wired"
+	TypeWireless = "wifi"
+	TypeInternal = "internal"
+	TypeAny      = "any"
+)
+
+// Network is a snapshot of the properties of a network object.
+type Network struct {
+	Path      dbus.ObjectPath
+	Name      string
+	Type      string
+	Bridge    string
+	Mac       string
+	Nat       bool
+	NatPrefix string
+	Active    bool
+}
+
+type Client interface {
+	List() ([]dbus.ObjectPath, error)
+	Networks() ([]Network, error)
+	Network(path dbus.ObjectPath) (Network, error)
+	CreateNetwork(netType string, id int32, config string) (dbus.ObjectPath, error)
+	RemoveNetwork(path dbus.ObjectPath) error
+
+	GetProperty(path dbus.ObjectPath, name string) (dbus.Variant, error)
+	SetProperty(path dbus.ObjectPath, name string, value interface{}) error
+
+	WatchNetworkAdded(ch chan<- dbus.ObjectPath) (func() error, error)
+	WatchNetworkRemoved(ch chan<- dbus.ObjectPath) (func() error, error)
+	WatchNetworkStateChanged(ch chan<- *NetworkStateChanged) (func() error, error)
+}
+
+type NetworkDaemon struct {
+	conn *dbus.Conn
+}
+
+// NewClient connects to the network daemon over the system bus, as in dom0.
+func NewClient() (Client, error) {
+	conn, err := argodbus.SystemBus()
+
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkDaemon{
+		conn: conn,
+	}, nil
+}
+
+// NewPlatformClient connects to the network daemon over the platform bus, which from a
+// guest or service VM is reached over argo.
+func NewPlatformClient() (Client, error) {
+	conn, err := argodbus.PlatformBus()
+
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkDaemon{
+		conn: conn,
+	}, nil
+}
+
+// NewClientConn returns a client using an established connection.
+func NewClientConn(conn *dbus.Conn) Client {
+	return &NetworkDaemon{
+		conn: conn,
+	}
+}
+
+//    <method name="list">
+//      <arg name="networks" type="ao" direction="out"/>
+//    </method>
+func (c *NetworkDaemon) List() ([]dbus.ObjectPath, error) {
+	obj := c.conn.Object(Service, "/")
+
+	var paths []dbus.ObjectPath
+	err := obj.Call(Interface+".list", 0).Store(&paths)
+
+	return paths, err
+}
+
+// Networks reads the properties of every network.
+func (c *NetworkDaemon) Networks() ([]Network, error) {
+	paths, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make([]Network, 0, len(paths))
+	for _, p := range paths {
+		n, err := c.Network(p)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// Network reads the properties of the network at path. Properties the
+// daemon does not report are left empty.
+func (c *NetworkDaemon) Network(path dbus.ObjectPath) (Network, error) {
+	obj := c.conn.Object(Service, path)
+
+	n := Network{Path: path}
+
+	var props map[string]dbus.Variant
+	err := obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, NetworkInterface).Store(&props)
+	if err != nil {
+		return n, err
+	}
+
+	fields := map[string]interface{}{
+		"name":       &n.Name,
+		"type":       &n.Type,
+		"bridge":     &n.Bridge,
+		"mac":        &n.Mac,
+		"nat":        &n.Nat,
+		"nat-prefix": &n.NatPrefix,
+		"active":     &n.Active,
+	}
+	for name, field := range fields {
+		v, ok := props[name]
+		if !ok {
+			continue
+		}
+		if err := dbus.Store([]interface{}{v.Value()}, field); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+//    <method name="create_network">
+//      <arg name="type" type="s" direction="in"/>
+//      <arg name="id" type="i" direction="in"/>
+//      <arg name="config" type="s" direction="in"/>
+//      <arg name="network" type="o" direction="out"/>
+//    </method>
+func (c *NetworkDaemon) CreateNetwork(netType string, id int32, config string) (dbus.ObjectPath, error) {
+	obj := c.conn.Object(Service, "/")
+
+	var path dbus.ObjectPath
+	err := obj.Call(Interface+".create_network", 0, netType, id, config).Store(&path)
+
+	return path, err
+}
+
+//    <method name="remove_network">
+//      <arg name="network" type="o" direction="in"/>
+//    </method>
+func (c *NetworkDaemon) RemoveNetwork(path dbus.ObjectPath) error {
+	obj := c.conn.Object(Service, "/")
+
+	call := obj.Call(Interface+".remove_network", 0, path)
+
+	return call.Err
+}
+
+// GetProperty reads a property of a network object, e.g. "bridge".
+func (c *NetworkDaemon) GetProperty(path dbus.ObjectPath, name string) (dbus.Variant, error) {
+	obj := c.conn.Object(Service, path)
+
+	return obj.GetProperty(NetworkInterface + "." + name)
+}
+
+// SetProperty sets a property of a network object.
+func (c *NetworkDaemon) SetProperty(path dbus.ObjectPath, name string, value interface{}) error {
+	obj := c.conn.Object(Service, path)
+
+	return obj.SetProperty(NetworkInterface+"."+name, dbus.MakeVariant(value))
+}
+
+func (c *NetworkDaemon) watchPath(member string, ch chan<- dbus.ObjectPath) (func() error, error) {
+	m := argodbus.SignalMatch{Interface: NotifyInterface, Member: member}
+	return argodbus.WatchSignal(c.conn, m, func(sig *dbus.Signal, done <-chan struct{}) {
+		var p dbus.ObjectPath
+		if err := dbus.Store(sig.Body, &p); err != nil {
+			return
+		}
+		select {
+		case ch <- p:
+		case <-done:
+		}
+	})
+}
+
+//    <signal name="network_added">
+//      <arg name="network" type="o"/>
+//    </signal>
+func (c *NetworkDaemon) WatchNetworkAdded(ch chan<- dbus.ObjectPath) (func() error, error) {
+	return c.watchPath("network_added", ch)
+}
+
+//    <signal name="network_removed">
+//      <arg name="network" type="o"/>
+//    </signal>
+func (c *NetworkDaemon) WatchNetworkRemoved(ch chan<- dbus.ObjectPath) (func() error, error) {
+	return c.watchPath("network_removed", ch)
+}
+
+// NetworkStateChanged carries the arguments of the network_state_changed
+// signal.
+type NetworkStateChanged struct {
+	Network dbus.ObjectPath
+	State   string
+}
+
+//    <signal name="network_state_changed">
+//      <arg name="network" type="o"/>
+//      <arg name="state" type="s"/>
+//    </signal>
+func (c *NetworkDaemon) WatchNetworkStateChanged(ch chan<- *NetworkStateChanged) (func() error, error) {
+	m := argodbus.SignalMatch{Interface: NotifyInterface, Member: "network_state_changed"}
+	return argodbus.WatchSignal(c.conn, m, func(sig *dbus.Signal, done <-chan struct{}) {
+		var s NetworkStateChanged
+		if err := dbus.Store(sig.Body, &s.Network, &s.State); err != nil {
+			return
+		}
+		select {
+		case ch <- &s:
+		case <-done:
+		}
+	})
+}
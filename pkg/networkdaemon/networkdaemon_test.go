@@ -0,0 +1,138 @@
+package networkdaemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+const brbridged = dbus.ObjectPath("/wired/0/bridged")
+
+type fakeDaemon struct {
+	removed chan dbus.ObjectPath
+}
+
+func (d *fakeDaemon) List() ([]dbus.ObjectPath, *dbus.Error) {
+	return []dbus.ObjectPath{brbridged}, nil
+}
+
+func (d *fakeDaemon) CreateNetwork(netType string, id int32, config string) (dbus.ObjectPath, *dbus.Error) {
+	if netType != TypeInternal {
+		return "", dbus.MakeFailedError(dbus.ErrMsgInvalidArg)
+	}
+	return "/internal/1", nil
+}
+
+func (d *fakeDaemon) RemoveNetwork(path dbus.ObjectPath) *dbus.Error {
+	d.removed <- path
+	return nil
+}
+
+// testClient connects a client to a fake network daemon over a test peer,
+// returning the server side to emit signals on.
+func testClient(t *testing.T) (Client, *fakeDaemon, *dbus.Conn) {
+	client, server, err := argodbus.NewTestPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &fakeDaemon{removed: make(chan dbus.ObjectPath, 1)}
+	methods := map[string]string{"List": "list", "CreateNetwork": "create_network", "RemoveNetwork": "remove_network"}
+	if err := server.ExportWithMap(d, methods, "/", Interface); err != nil {
+		t.Fatal(err)
+	}
+	_, err = prop.Export(server, brbridged, map[string]map[string]*prop.Prop{NetworkInterface: {
+		"name":       {Value: "Ethernet", Emit: prop.EmitFalse},
+		"type":       {Value: TypeWired, Emit: prop.EmitFalse},
+		"bridge":     {Value: "brbridged", Emit: prop.EmitFalse},
+		"nat":        {Value: false, Emit: prop.EmitFalse},
+		"active":     {Value: true, Writable: true, Emit: prop.EmitFalse},
+		"extra-prop": {Value: uint32(1), Emit: prop.EmitFalse},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewClientConn(client), d, server
+}
+
+func TestClient(t *testing.T) {
+	c, d, server := testClient(t)
+	defer server.Close()
+
+	networks, err := c.Networks()
+	if err != nil || len(networks) != 1 {
+		t.Fatalf("Networks = %v, %v", networks, err)
+	}
+	want := Network{Path: brbridged, Name: "Ethernet", Type: TypeWired, Bridge: "brbridged", Active: true}
+	if networks[0] != want {
+		t.Errorf("network %+v, want %+v", networks[0], want)
+	}
+
+	if err := c.SetProperty(brbridged, "active", false); err != nil {
+		t.Fatalf("SetProperty error: %v", err)
+	}
+	if v, err := c.GetProperty(brbridged, "active"); err != nil || v.Value() != false {
+		t.Errorf("GetProperty = %v, %v", v, err)
+	}
+
+	path, err := c.CreateNetwork(TypeInternal, 1, "")
+	if err != nil || path != "/internal/1" {
+		t.Errorf("CreateNetwork = %v, %v", path, err)
+	}
+	if _, err := c.CreateNetwork("bogus", 1, ""); err == nil {
+		t.Errorf("CreateNetwork of a bad type succeeded")
+	}
+
+	if err := c.RemoveNetwork(path); err != nil {
+		t.Fatalf("RemoveNetwork error: %v", err)
+	}
+	if removed := <-d.removed; removed != path {
+		t.Errorf("removed %v", removed)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c, _, server := testClient(t)
+	defer server.Close()
+
+	added := make(chan dbus.ObjectPath, 1)
+	stopAdded, err := c.WatchNetworkAdded(added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopAdded()
+
+	changed := make(chan *NetworkStateChanged, 1)
+	stopChanged, err := c.WatchNetworkStateChanged(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopChanged()
+
+	// network_removed is not watched and must not reach either channel
+	server.Emit("/", NotifyInterface+".network_removed", brbridged)
+	server.Emit("/", NotifyInterface+".network_added", brbridged)
+	server.Emit("/", NotifyInterface+".network_state_changed", brbridged, "connected")
+
+	select {
+	case p := <-added:
+		if p != brbridged {
+			t.Errorf("network_added %v", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("network_added not delivered")
+	}
+
+	select {
+	case s := <-changed:
+		if s.Network != brbridged || s.State != "connected" {
+			t.Errorf("network_state_changed %+v", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("network_state_changed not delivered")
+	}
+}
@@ -0,0 +1,202 @@
+package usbdaemon
+
+import (
+	"github.com/godbus/dbus/v5"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+const (
+	Service   = "com.citrix.xenclient.usbdaemon"
+	Interface = "com.citrix.xenclient.usbdaemon"
+)
+
+// Device states reported by get_device_info
+const (
+	StateUnused         = 0
+	StateAssigned       = 1
+	StateInUse          = 2
+	StateBlocked        = 3
+	StatePlatformDevice = 4
+	StateSticky         = 5
+)
+
+// DeviceInfo describes a device as seen from a VM.
+type DeviceInfo struct {
+	Id         int32
+	Name       string
+	State      int32
+	VmAssigned string
+	Detail     string
+}
+
+type Client interface {
+	ListDevices() ([]int32, error)
+	GetDeviceInfo(dev int32, vmUuid string) (DeviceInfo, error)
+	AssignDevice(dev int32, vmUuid string) error
+	UnassignDevice(dev int32) error
+	NameDevice(dev int32, name string) error
+
+	GetSticky(dev int32) (bool, error)
+	SetSticky(dev int32, sticky bool) error
+
+	WatchDeviceAdded(ch chan<- int32) (func() error, error)
+	WatchDeviceRemoved(ch chan<- int32) (func() error, error)
+}
+
+type UsbDaemon struct {
+	conn *dbus.Conn
+}
+
+// NewClient connects to the USB daemon over the system bus.
+func NewClient() (Client, error) {
+	conn, err := argodbus.SystemBus()
+
+	if err != nil {
+		return nil, err
+	}
+	return &UsbDaemon{
+		conn: conn,
+	}, nil
+}
+
+// NewPlatformClient connects to the USB daemon over the platform bus,
+// which from a guest is reached over argo.
+func NewPlatformClient() (Client, error) {
+	conn, err := argodbus.PlatformBus()
+
+	if err != nil {
+		return nil, err
+	}
+	return &UsbDaemon{
+		conn: conn,
+	}, nil
+}
+
+// NewClientConn returns a client using an established connection.
+func NewClientConn(conn *dbus.Conn) Client {
+	return &UsbDaemon{
+		conn: conn,
+	}
+}
+
+//    <method name="list_devices">
+//      <arg name="devices" type="ai" direction="out"/>
+//    </method>
+func (c *UsbDaemon) ListDevices() ([]int32, error) {
+	obj := c.conn.Object(Service, "/")
+
+	var devs []int32
+	err := obj.Call(Interface+".list_devices", 0).Store(&devs)
+
+	return devs, err
+}
+
+//    <method name="get_device_info">
+//      <arg name="dev_id" type="i" direction="in"/>
+//      <arg name="vm_uuid" type="s" direction="in"/>
+//      <arg name="name" type="s" direction="out"/>
+//      <arg name="state" type="i" direction="out"/>
+//      <arg name="vm_assigned" type="s" direction="out"/>
+//      <arg name="detail" type="s" direction="out"/>
+//    </method>
+func (c *UsbDaemon) GetDeviceInfo(dev int32, vmUuid string) (DeviceInfo, error) {
+	obj := c.conn.Object(Service, "/")
+
+	info := DeviceInfo{Id: dev}
+	err := obj.Call(Interface+".get_device_info", 0, dev, vmUuid).Store(
+		&info.Name, &info.State, &info.VmAssigned, &info.Detail)
+
+	return info, err
+}
+
+//    <method name="assign_device">
+//      <arg name="dev_id" type="i" direction="in"/>
+//      <arg name="vm_uuid" type="s" direction="in"/>
+//    </method>
+func (c *UsbDaemon) AssignDevice(dev int32, vmUuid string) error {
+	obj := c.conn.Object(Service, "/")
+
+	call := obj.Call(Interface+".assign_device", 0, dev, vmUuid)
+
+	return call.Err
+}
+
+//    <method name="unassign_device">
+//      <arg name="dev_id" type="i" direction="in"/>
+//    </method>
+func (c *UsbDaemon) UnassignDevice(dev int32) error {
+	obj := c.conn.Object(Service, "/")
+
+	call := obj.Call(Interface+".unassign_device", 0, dev)
+
+	return call.Err
+}
+
+//    <method name="name_device">
+//      <arg name="dev_id" type="i" direction="in"/>
+//      <arg name="name" type="s" direction="in"/>
+//    </method>
+func (c *UsbDaemon) NameDevice(dev int32, name string) error {
+	obj := c.conn.Object(Service, "/")
+
+	call := obj.Call(Interface+".name_device", 0, dev, name)
+
+	return call.Err
+}
+
+//    <method name="get_sticky">
+//      <arg name="dev_id" type="i" direction="in"/>
+//      <arg name="sticky" type="i" direction="out"/>
+//    </method>
+func (c *UsbDaemon) GetSticky(dev int32) (bool, error) {
+	obj := c.conn.Object(Service, "/")
+
+	var sticky int32
+	err := obj.Call(Interface+".get_sticky", 0, dev).Store(&sticky)
+
+	return sticky != 0, err
+}
+
+//    <method name="set_sticky">
+//      <arg name="dev_id" type="i" direction="in"/>
+//      <arg name="sticky" type="i" direction="in"/>
+//    </method>
+func (c *UsbDaemon) SetSticky(dev int32, sticky bool) error {
+	obj := c.conn.Object(Service, "/")
+
+	var s int32
+	if sticky {
+		s = 1
+	}
+	call := obj.Call(Interface+".set_sticky", 0, dev, s)
+
+	return call.Err
+}
+
+func (c *UsbDaemon) watchDevice(member string, ch chan<- int32) (func() error, error) {
+	m := argodbus.SignalMatch{Interface: Interface, Member: member}
+	return argodbus.WatchSignal(c.conn, m, func(sig *dbus.Signal, done <-chan struct{}) {
+		var dev int32
+		if err := dbus.Store(sig.Body, &dev); err != nil {
+			return
+		}
+		select {
+		case ch <- dev:
+		case <-done:
+		}
+	})
+}
+
+//    <signal name="device_added">
+//      <arg name="dev_id" type="i"/>
+//    </signal>
+func (c *UsbDaemon) WatchDeviceAdded(ch chan<- int32) (func() error, error) {
+	return c.watchDevice("device_added", ch)
+}
+
+//    <signal name="device_removed">
+//      <arg name="dev_id" type="i"/>
+//    </signal>
+func (c *UsbDaemon) WatchDeviceRemoved(ch chan<- int32) (func() error, error) {
+	return c.watchDevice("device_removed", ch)
+}
@@ -0,0 +1,132 @@
+package usbdaemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+const vmUuid = "00000000-0000-0000-0000-000000000001"
+
+type fakeDaemon struct {
+	assigned map[int32]string
+	sticky   map[int32]int32
+}
+
+func (d *fakeDaemon) ListDevices() ([]int32, *dbus.Error) {
+	return []int32{1, 2}, nil
+}
+
+func (d *fakeDaemon) GetDeviceInfo(dev int32, uuid string) (string, int32, string, string, *dbus.Error) {
+	if dev != 1 && dev != 2 {
+		return "", 0, "", "", dbus.MakeFailedError(dbus.ErrMsgInvalidArg)
+	}
+	state := int32(StateUnused)
+	if d.assigned[dev] == uuid {
+		state = StateAssigned
+	}
+	return "Keyboard", state, d.assigned[dev], "046d:c31c", nil
+}
+
+func (d *fakeDaemon) AssignDevice(dev int32, uuid string) *dbus.Error {
+	d.assigned[dev] = uuid
+	return nil
+}
+
+func (d *fakeDaemon) GetSticky(dev int32) (int32, *dbus.Error) {
+	return d.sticky[dev], nil
+}
+
+func (d *fakeDaemon) SetSticky(dev int32, sticky int32) *dbus.Error {
+	d.sticky[dev] = sticky
+	return nil
+}
+
+// testClient connects a client to a fake USB daemon over a test peer,
+// returning the server side to emit signals on. The tests make one call at
+// a time, so the fake needs no locking.
+func testClient(t *testing.T) (Client, *dbus.Conn) {
+	client, server, err := argodbus.NewTestPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &fakeDaemon{assigned: make(map[int32]string), sticky: make(map[int32]int32)}
+	methods := map[string]string{
+		"ListDevices":   "list_devices",
+		"GetDeviceInfo": "get_device_info",
+		"AssignDevice":  "assign_device",
+		"GetSticky":     "get_sticky",
+		"SetSticky":     "set_sticky",
+	}
+	if err := server.ExportWithMap(d, methods, "/", Interface); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewClientConn(client), server
+}
+
+func TestClient(t *testing.T) {
+	c, server := testClient(t)
+	defer server.Close()
+
+	devs, err := c.ListDevices()
+	if err != nil || len(devs) != 2 {
+		t.Fatalf("ListDevices = %v, %v", devs, err)
+	}
+
+	if err := c.AssignDevice(1, vmUuid); err != nil {
+		t.Fatalf("AssignDevice error: %v", err)
+	}
+	info, err := c.GetDeviceInfo(1, vmUuid)
+	if err != nil {
+		t.Fatalf("GetDeviceInfo error: %v", err)
+	}
+	want := DeviceInfo{Id: 1, Name: "Keyboard", State: StateAssigned, VmAssigned: vmUuid, Detail: "046d:c31c"}
+	if info != want {
+		t.Errorf("device info %+v, want %+v", info, want)
+	}
+	if _, err := c.GetDeviceInfo(9, vmUuid); err == nil {
+		t.Errorf("GetDeviceInfo of a missing device succeeded")
+	}
+
+	// sticky is an int32 on the bus
+	if err := c.SetSticky(2, true); err != nil {
+		t.Fatalf("SetSticky error: %v", err)
+	}
+	if sticky, err := c.GetSticky(2); err != nil || !sticky {
+		t.Errorf("GetSticky = %v, %v", sticky, err)
+	}
+	if sticky, err := c.GetSticky(1); err != nil || sticky {
+		t.Errorf("GetSticky of a device never set = %v, %v", sticky, err)
+	}
+}
+
+func TestWatchDevices(t *testing.T) {
+	c, server := testClient(t)
+	defer server.Close()
+
+	added := make(chan int32, 1)
+	stop, err := c.WatchDeviceAdded(added)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Emit("/", Interface+".device_removed", int32(1))
+	server.Emit("/", Interface+".device_added", int32(2))
+
+	select {
+	case dev := <-added:
+		if dev != 2 {
+			t.Errorf("device_added %d", dev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("device_added not delivered")
+	}
+
+	if err := stop(); err != nil {
+		t.Errorf("stop error: %v", err)
+	}
+}
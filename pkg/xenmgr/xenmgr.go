@@ -0,0 +1,251 @@
+package xenmgr
+
+import (
+	"github.com/godbus/dbus/v5"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+const (
+	Service     = "com.citrix.xenclient.xenmgr"
+	Interface   = "com.citrix.xenclient.xenmgr"
+	VmInterface = "com.citrix.xenclient.xenmgr.vm"
+)
+
+// VM states reported by the state property and vm_state_changed
+const (
+	StateCreating  = "creating"
+	StateStopped   = "stopped"
+	StateRunning   = "running"
+	StatePaused    = "paused"
+	StateStopping  = "stopping"
+	StateRebooting = "rebooting"
+	StateSuspended = "suspended"
+)
+
+type Client interface {
+	ListVms() ([]dbus.ObjectPath, error)
+	FindVmByUuid(uuid string) (dbus.ObjectPath, error)
+	FindVmByDomid(domid int32) (dbus.ObjectPath, error)
+
+	Start(vm dbus.ObjectPath) error
+	Shutdown(vm dbus.ObjectPath) error
+	Reboot(vm dbus.ObjectPath) error
+	Pause(vm dbus.ObjectPath) error
+	Unpause(vm dbus.ObjectPath) error
+	Destroy(vm dbus.ObjectPath) error
+
+	GetProperty(vm dbus.ObjectPath, name string) (dbus.Variant, error)
+	SetProperty(vm dbus.ObjectPath, name string, value interface{}) error
+	GetAllProperties(vm dbus.ObjectPath) (map[string]dbus.Variant, error)
+	Name(vm dbus.ObjectPath) (string, error)
+	Uuid(vm dbus.ObjectPath) (string, error)
+	State(vm dbus.ObjectPath) (string, error)
+	Domid(vm dbus.ObjectPath) (int32, error)
+
+	WatchVmStateChanged(ch chan<- *VmStateChanged) (func() error, error)
+}
+
+type Xenmgr struct {
+	conn *dbus.Conn
+}
+
+// NewClient connects to xenmgr over the system bus, as in dom0.
+func NewClient() (Client, error) {
+	conn, err := argodbus.SystemBus()
+
+	if err != nil {
+		return nil, err
+	}
+	return &Xenmgr{
+		conn: conn,
+	}, nil
+}
+
+// NewPlatformClient connects to xenmgr over the platform bus, which from a
+// guest or service VM is reached over argo.
+func NewPlatformClient() (Client, error) {
+	conn, err := argodbus.PlatformBus()
+
+	if err != nil {
+		return nil, err
+	}
+	return &Xenmgr{
+		conn: conn,
+	}, nil
+}
+
+// NewClientConn returns a client using an established connection.
+func NewClientConn(conn *dbus.Conn) Client {
+	return &Xenmgr{
+		conn: conn,
+	}
+}
+
+//    <method name="list_vms">
+//      <arg name="paths" type="ao" direction="out"/>
+//    </method>
+func (c *Xenmgr) ListVms() ([]dbus.ObjectPath, error) {
+	obj := c.conn.Object(Service, "/")
+
+	var paths []dbus.ObjectPath
+	err := obj.Call(Interface+".list_vms", 0).Store(&paths)
+
+	return paths, err
+}
+
+//    <method name="find_vm_by_uuid">
+//      <arg name="uuid" type="s" direction="in"/>
+//      <arg name="obj_path" type="o" direction="out"/>
+//    </method>
+func (c *Xenmgr) FindVmByUuid(uuid string) (dbus.ObjectPath, error) {
+	obj := c.conn.Object(Service, "/")
+
+	var path dbus.ObjectPath
+	err := obj.Call(Interface+".find_vm_by_uuid", 0, uuid).Store(&path)
+
+	return path, err
+}
+
+//    <method name="find_vm_by_domid">
+//      <arg name="domid" type="i" direction="in"/>
+//      <arg name="obj_path" type="o" direction="out"/>
+//    </method>
+func (c *Xenmgr) FindVmByDomid(domid int32) (dbus.ObjectPath, error) {
+	obj := c.conn.Object(Service, "/")
+
+	var path dbus.ObjectPath
+	err := obj.Call(Interface+".find_vm_by_domid", 0, domid).Store(&path)
+
+	return path, err
+}
+
+func (c *Xenmgr) vmCall(vm dbus.ObjectPath, method string) error {
+	obj := c.conn.Object(Service, vm)
+
+	call := obj.Call(VmInterface+"."+method, 0)
+
+	return call.Err
+}
+
+//    <method name="start"/>
+func (c *Xenmgr) Start(vm dbus.ObjectPath) error {
+	return c.vmCall(vm, "start")
+}
+
+//    <method name="shutdown"/>
+func (c *Xenmgr) Shutdown(vm dbus.ObjectPath) error {
+	return c.vmCall(vm, "shutdown")
+}
+
+//    <method name="reboot"/>
+func (c *Xenmgr) Reboot(vm dbus.ObjectPath) error {
+	return c.vmCall(vm, "reboot")
+}
+
+//    <method name="pause"/>
+func (c *Xenmgr) Pause(vm dbus.ObjectPath) error {
+	return c.vmCall(vm, "pause")
+}
+
+//    <method name="unpause"/>
+func (c *Xenmgr) Unpause(vm dbus.ObjectPath) error {
+	return c.vmCall(vm, "unpause")
+}
+
+//    <method name="destroy"/>
+func (c *Xenmgr) Destroy(vm dbus.ObjectPath) error {
+	return c.vmCall(vm, "destroy")
+}
+
+// GetProperty reads a property of the VM interface, e.g. "name".
+func (c *Xenmgr) GetProperty(vm dbus.ObjectPath, name string) (dbus.Variant, error) {
+	obj := c.conn.Object(Service, vm)
+
+	return obj.GetProperty(VmInterface + "." + name)
+}
+
+// SetProperty sets a property of the VM interface.
+func (c *Xenmgr) SetProperty(vm dbus.ObjectPath, name string, value interface{}) error {
+	obj := c.conn.Object(Service, vm)
+
+	return obj.SetProperty(VmInterface+"."+name, dbus.MakeVariant(value))
+}
+
+// GetAllProperties reads every property of the VM interface.
+func (c *Xenmgr) GetAllProperties(vm dbus.ObjectPath) (map[string]dbus.Variant, error) {
+	obj := c.conn.Object(Service, vm)
+
+	var props map[string]dbus.Variant
+	err := obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, VmInterface).Store(&props)
+
+	return props, err
+}
+
+func (c *Xenmgr) property(vm dbus.ObjectPath, name string, value interface{}) error {
+	v, err := c.GetProperty(vm, name)
+	if err != nil {
+		return err
+	}
+
+	return dbus.Store([]interface{}{v.Value()}, value)
+}
+
+//    <property name="name" type="s" access="readwrite"/>
+func (c *Xenmgr) Name(vm dbus.ObjectPath) (string, error) {
+	var s string
+	err := c.property(vm, "name", &s)
+
+	return s, err
+}
+
+//    <property name="uuid" type="s" access="read"/>
+func (c *Xenmgr) Uuid(vm dbus.ObjectPath) (string, error) {
+	var s string
+	err := c.property(vm, "uuid", &s)
+
+	return s, err
+}
+
+//    <property name="state" type="s" access="read"/>
+func (c *Xenmgr) State(vm dbus.ObjectPath) (string, error) {
+	var s string
+	err := c.property(vm, "state", &s)
+
+	return s, err
+}
+
+//    <property name="domid" type="i" access="read"/>
+func (c *Xenmgr) Domid(vm dbus.ObjectPath) (int32, error) {
+	var i int32
+	err := c.property(vm, "domid", &i)
+
+	return i, err
+}
+
+// VmStateChanged carries the arguments of the vm_state_changed signal.
+type VmStateChanged struct {
+	Uuid      string
+	Path      dbus.ObjectPath
+	State     string
+	AcpiState int32
+}
+
+//    <signal name="vm_state_changed">
+//      <arg name="uuid" type="s"/>
+//      <arg name="obj_path" type="o"/>
+//      <arg name="state" type="s"/>
+//      <arg name="acpi_state" type="i"/>
+//    </signal>
+func (c *Xenmgr) WatchVmStateChanged(ch chan<- *VmStateChanged) (func() error, error) {
+	m := argodbus.SignalMatch{Interface: Interface, Member: "vm_state_changed"}
+	return argodbus.WatchSignal(c.conn, m, func(sig *dbus.Signal, done <-chan struct{}) {
+		var s VmStateChanged
+		if err := dbus.Store(sig.Body, &s.Uuid, &s.Path, &s.State, &s.AcpiState); err != nil {
+			return
+		}
+		select {
+		case ch <- &s:
+		case <-done:
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package xenmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	argodbus "github.com/openxt/openxt-go/pkg/argo/dbus"
+)
+
+const vmPath = dbus.ObjectPath("/vm/00000000_0000_0000_0000_000000000001")
+
+type fakeXenmgr struct {
+	started chan dbus.ObjectPath
+}
+
+func (x *fakeXenmgr) ListVms() ([]dbus.ObjectPath, *dbus.Error) {
+	return []dbus.ObjectPath{vmPath}, nil
+}
+
+func (x *fakeXenmgr) FindVmByUuid(uuid string) (dbus.ObjectPath, *dbus.Error) {
+	if uuid != "00000000-0000-0000-0000-000000000001" {
+		return "", dbus.MakeFailedError(dbus.ErrMsgNoObject)
+	}
+	return vmPath, nil
+}
+
+type fakeVm struct {
+	x *fakeXenmgr
+}
+
+func (v fakeVm) Start() *dbus.Error {
+	v.x.started <- vmPath
+	return nil
+}
+
+// testClient connects a client to a fake xenmgr over a test peer,
+// returning the server side to emit signals on.
+func testClient(t *testing.T) (Client, *fakeXenmgr, *dbus.Conn) {
+	client, server, err := argodbus.NewTestPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := &fakeXenmgr{started: make(chan dbus.ObjectPath, 1)}
+	methods := map[string]string{"ListVms": "list_vms", "FindVmByUuid": "find_vm_by_uuid"}
+	if err := server.ExportWithMap(x, methods, "/", Interface); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ExportWithMap(fakeVm{x}, map[string]string{"Start": "start"}, vmPath, VmInterface); err != nil {
+		t.Fatal(err)
+	}
+	_, err = prop.Export(server, vmPath, map[string]map[string]*prop.Prop{VmInterface: {
+		"name":  {Value: "ndvm", Writable: true, Emit: prop.EmitFalse},
+		"state": {Value: StateRunning, Emit: prop.EmitFalse},
+		"domid": {Value: int32(3), Emit: prop.EmitFalse},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewClientConn(client), x, server
+}
+
+func TestClient(t *testing.T) {
+	c, x, server := testClient(t)
+	defer server.Close()
+
+	vms, err := c.ListVms()
+	if err != nil || len(vms) != 1 || vms[0] != vmPath {
+		t.Fatalf("ListVms = %v, %v", vms, err)
+	}
+
+	vm, err := c.FindVmByUuid("00000000-0000-0000-0000-000000000001")
+	if err != nil || vm != vmPath {
+		t.Errorf("FindVmByUuid = %v, %v", vm, err)
+	}
+	if _, err := c.FindVmByUuid("missing"); err == nil {
+		t.Errorf("FindVmByUuid of a missing VM succeeded")
+	}
+
+	if err := c.Start(vm); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if started := <-x.started; started != vmPath {
+		t.Errorf("started %v", started)
+	}
+
+	if state, err := c.State(vm); err != nil || state != StateRunning {
+		t.Errorf("State = %q, %v", state, err)
+	}
+	if domid, err := c.Domid(vm); err != nil || domid != 3 {
+		t.Errorf("Domid = %d, %v", domid, err)
+	}
+	if err := c.SetProperty(vm, "name", "uivm"); err != nil {
+		t.Fatalf("SetProperty error: %v", err)
+	}
+	if name, err := c.Name(vm); err != nil || name != "uivm" {
+		t.Errorf("Name = %q, %v", name, err)
+	}
+	props, err := c.GetAllProperties(vm)
+	if err != nil || len(props) != 3 {
+		t.Errorf("GetAllProperties = %v, %v", props, err)
+	}
+}
+
+func TestWatchVmStateChanged(t *testing.T) {
+	c, _, server := testClient(t)
+	defer server.Close()
+
+	ch := make(chan *VmStateChanged, 1)
+	stop, err := c.WatchVmStateChanged(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	server.Emit("/", Interface+".vm_state_changed", "00000000-0000-0000-0000-000000000001", vmPath, StateStopping, int32(0))
+
+	select {
+	case s := <-ch:
+		if s.Path != vmPath || s.State != StateStopping {
+			t.Errorf("got %+v", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("vm_state_changed not delivered")
+	}
+}